@@ -0,0 +1,359 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// ----------------------------------
+// Admin API
+// ----------------------------------
+
+// adminAPI serves runtime operations on a separate listener, authenticated
+// with a static bearer token. Blocks and cache purges apply to every gateway
+// replica; everything else it changes applies to this gateway instance only
+// and is lost on restart.
+type adminAPI struct {
+	token       string
+	disc        *Discovery
+	routes      *routeStore
+	rl          *redisRateLimiter
+	cache       *responseCache
+	blocks      *blockList
+	faults      *faultInjector // nil when fault injection is disabled
+	version     string
+	environment string
+	logger      *logrus.Logger
+}
+
+// router builds the admin endpoints.
+func (a *adminAPI) router() *gin.Engine {
+	r := gin.New()
+	r.Use(gin.Recovery(), a.authenticate())
+
+	r.GET("/version", a.getVersion)
+	r.GET("/routes", a.getRoutes)
+	r.GET("/instances", a.getInstances)
+	r.GET("/breakers", a.getBreakers)
+	r.GET("/rate-limits", a.getRateLimits)
+
+	r.POST("/instances/:service/:id/drain", a.setMode(instanceDraining))
+	r.POST("/instances/:service/:id/disable", a.setMode(instanceDisabled))
+	r.POST("/instances/:service/:id/enable", a.setMode(instanceActive))
+	r.POST("/discovery/refresh", a.refreshDiscovery)
+
+	r.GET("/cache", a.getCache)
+	r.POST("/cache/purge", a.purgeCache)
+
+	r.GET("/blocks", a.getBlocks)
+	r.POST("/blocks", a.addBlock)
+	// CIDR prefixes contain a slash, so the value is the rest of the path
+	r.DELETE("/blocks/:type/*value", a.removeBlock)
+
+	if a.faults != nil {
+		r.GET("/faults", a.getFaults)
+		r.POST("/faults", a.addFault)
+		r.DELETE("/faults", a.clearFaults)
+		r.DELETE("/faults/:id", a.removeFault)
+	}
+	return r
+}
+
+func (a *adminAPI) authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, bearer := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !bearer || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func (a *adminAPI) getVersion(c *gin.Context) {
+	build := gin.H{"go_version": runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision", "vcs.time", "vcs.modified":
+				build[strings.TrimPrefix(s.Key, "vcs.")] = s.Value
+			}
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"version":     a.version,
+		"environment": a.environment,
+		"uptime":      time.Since(startTime).String(),
+		"build":       build,
+	})
+}
+
+// getRoutes lists the effective routes, longest prefix first, which is the
+// order they are matched in.
+func (a *adminAPI) getRoutes(c *gin.Context) {
+	table := a.routes.Load()
+	routes := make([]gin.H, 0, len(table.routes))
+	for _, r := range table.routes {
+		authRules := make([]gin.H, 0, len(r.AuthRules))
+		for _, rule := range r.AuthRules {
+			authRules = append(authRules, gin.H{"path": rule.Path, "methods": rule.Methods, "auth": rule.Auth})
+		}
+		rateLimits := make([]gin.H, 0, len(r.RateLimits))
+		for _, rule := range r.RateLimits {
+			rateLimits = append(rateLimits, gin.H{"path": rule.Path, "methods": rule.Methods, "policy": rule.Policy})
+		}
+		auth, rateLimit := r.Auth, r.RateLimit
+		if auth == "" {
+			auth = authRequired
+		}
+		if rateLimit == "" {
+			rateLimit = defaultRateLimitPolicy
+		}
+		routes = append(routes, gin.H{
+			"prefix":      r.Prefix,
+			"service":     r.ServiceName,
+			"rewrite":     r.RewritePrefix,
+			"methods":     r.Methods,
+			"auth":        auth,
+			"auth_rules":  authRules,
+			"timeout":     r.timeout().String(),
+			"rate_limit":  rateLimit,
+			"rate_limits": rateLimits,
+			"health_path": table.healthPath(r.ServiceName),
+			"docs_path":   table.docsPath(r.ServiceName),
+			"transport":   r.Transport.summary(),
+			"request":     r.Request.summary(),
+			"versions":    r.Versions,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"loaded_at": table.loadedAt, "routes": routes})
+}
+
+// getInstances lists every discovered instance with its mode, load, health
+// checks and circuit breaker.
+func (a *adminAPI) getInstances(c *gin.Context) {
+	health := a.disc.HealthStatus()
+	services := make(map[string][]gin.H)
+	for name, b := range a.disc.BreakerStatus() {
+		for _, inst := range a.disc.Instances(name) {
+			services[name] = append(services[name], gin.H{
+				"id":          inst.ID,
+				"address":     inst.Address,
+				"version":     inst.Version,
+				"mode":        inst.Mode().String(),
+				"outstanding": inst.Outstanding(),
+				"health":      health[name][inst.ID],
+				"breaker":     b.Instances[inst.ID],
+			})
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"services": services})
+}
+
+func (a *adminAPI) getBreakers(c *gin.Context) {
+	c.JSON(http.StatusOK, a.disc.BreakerStatus())
+}
+
+func (a *adminAPI) getRateLimits(c *gin.Context) {
+	table := a.routes.Load()
+	c.JSON(http.StatusOK, gin.H{
+		"backend":        a.rl.Backend(),
+		"policies":       rateLimitSummary(table.policies),
+		"local_counters": a.rl.fallback.Len(),
+	})
+}
+
+// setMode drains, disables or re-enables an instance. The response reports
+// the requests still in flight, so that a drain can be watched until they
+// reach zero.
+func (a *adminAPI) setMode(mode instanceMode) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		service, id := c.Param("service"), c.Param("id")
+		inst := a.disc.SetMode(service, id, mode)
+		if inst == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Instance not found"})
+			return
+		}
+		a.logger.WithFields(logrus.Fields{"service": service, "instance": id, "mode": mode.String()}).Warn("Instance mode changed through the admin API")
+		c.JSON(http.StatusOK, gin.H{
+			"id":          inst.ID,
+			"address":     inst.Address,
+			"mode":        inst.Mode().String(),
+			"outstanding": inst.Outstanding(),
+		})
+	}
+}
+
+func (a *adminAPI) refreshDiscovery(c *gin.Context) {
+	if err := a.disc.refresh(); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Registry refresh failed: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"services": a.disc.GetAllServices()})
+}
+
+func (a *adminAPI) getCache(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"backend":         a.cache.Backend(),
+		"local_responses": a.cache.local.Len(),
+	})
+}
+
+type purgeRequest struct {
+	Keys []string `json:"keys" binding:"required,min=1"`
+}
+
+// purgeCache drops the cached responses tagged with any of the surrogate
+// keys, on every gateway replica, e.g. {"keys": ["post:42"]}.
+func (a *adminAPI) purgeCache(c *gin.Context) {
+	var req purgeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "keys must list at least one surrogate key"})
+		return
+	}
+	if err := a.cache.Purge(c.Request.Context(), req.Keys); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Purged locally, but not on other replicas: " + err.Error()})
+		return
+	}
+	a.logger.WithField("keys", req.Keys).Warn("Response cache purged through the admin API")
+	c.JSON(http.StatusOK, gin.H{"purged": req.Keys})
+}
+
+func (a *adminAPI) getBlocks(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"blocks": a.blocks.list()})
+}
+
+type blockRequest struct {
+	Type   blockKind `json:"type" binding:"required"`
+	Value  string    `json:"value" binding:"required"`
+	TTL    string    `json:"ttl" binding:"required"`
+	Reason string    `json:"reason"`
+}
+
+// addBlock adds an entry to the access lists for a limited time, e.g.
+// {"type": "deny", "value": "203.0.113.0/24", "ttl": "15m"}.
+func (a *adminAPI) addBlock(c *gin.Context) {
+	var req blockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	ttl, err := time.ParseDuration(req.TTL)
+	if err != nil || ttl <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ttl must be a positive duration such as 15m"})
+		return
+	}
+	b := block{Kind: req.Type, Value: req.Value, Reason: req.Reason, ExpiresAt: time.Now().Add(ttl)}
+	if err := b.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := a.blocks.add(c.Request.Context(), b); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to store block: " + err.Error()})
+		return
+	}
+	a.logger.WithFields(logrus.Fields{"type": b.Kind, "value": b.Value, "ttl": ttl.String(), "reason": b.Reason}).Warn("Block added through the admin API")
+	c.JSON(http.StatusCreated, b)
+}
+
+func (a *adminAPI) removeBlock(c *gin.Context) {
+	b := block{Kind: blockKind(c.Param("type")), Value: strings.TrimPrefix(c.Param("value"), "/")}
+	if err := b.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	removed, err := a.blocks.remove(c.Request.Context(), b)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to remove block: " + err.Error()})
+		return
+	}
+	if !removed {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Block not found"})
+		return
+	}
+	a.logger.WithFields(logrus.Fields{"type": b.Kind, "value": b.Value}).Warn("Block removed through the admin API")
+	c.Status(http.StatusNoContent)
+}
+
+func (a *adminAPI) getFaults(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"faults": a.faults.list()})
+}
+
+type faultRequest struct {
+	Service string      `json:"service"`
+	Path    string      `json:"path"`
+	Methods []string    `json:"methods"`
+	Header  *valueMatch `json:"header"`
+	Percent float64     `json:"percent" binding:"required"`
+	Delay   string      `json:"delay"`
+	Status  int         `json:"status"`
+	Abort   bool        `json:"abort"`
+	TTL     string      `json:"ttl" binding:"required"`
+}
+
+// addFault injects a fault for a limited time, e.g.
+// {"service": "comment-service", "percent": 50, "delay": "2s", "ttl": "10m"}.
+func (a *adminAPI) addFault(c *gin.Context) {
+	var req faultRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	ttl, err := time.ParseDuration(req.TTL)
+	if err != nil || ttl <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ttl must be a positive duration such as 10m"})
+		return
+	}
+	var delay time.Duration
+	if req.Delay != "" {
+		if delay, err = time.ParseDuration(req.Delay); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "delay must be a duration such as 2s"})
+			return
+		}
+	}
+
+	rule := &faultRule{
+		Service:   req.Service,
+		Path:      req.Path,
+		Methods:   req.Methods,
+		Header:    req.Header,
+		Percent:   req.Percent,
+		Delay:     delay,
+		Status:    req.Status,
+		Abort:     req.Abort,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := rule.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	a.faults.add(rule)
+	a.logger.WithFields(logrus.Fields{"fault": rule.ID, "service": rule.Service, "path": rule.Path, "percent": rule.Percent, "ttl": ttl.String()}).Warn("Fault injection added through the admin API")
+	c.JSON(http.StatusCreated, rule)
+}
+
+func (a *adminAPI) removeFault(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || !a.faults.remove(id) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Fault not found"})
+		return
+	}
+	a.logger.WithField("fault", id).Warn("Fault injection removed through the admin API")
+	c.Status(http.StatusNoContent)
+}
+
+func (a *adminAPI) clearFaults(c *gin.Context) {
+	n := a.faults.clear()
+	a.logger.WithField("count", n).Warn("Fault injections cleared through the admin API")
+	c.JSON(http.StatusOK, gin.H{"removed": n})
+}
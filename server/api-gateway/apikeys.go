@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ----------------------------------
+// API keys
+// ----------------------------------
+
+// The auth service publishes every active API key to Redis under the
+// SHA-256 of the key, so the gateway resolves keys without ever seeing them
+// stored. Revoking a key deletes its record; expiry lets Redis drop it.
+const (
+	apiKeyScheme      = "ApiKey "
+	apiKeyRedisPrefix = "apikey:"
+)
+
+var errInvalidAPIKey = errors.New("invalid API key")
+
+// apiKeyRecord is the caller an API key stands for.
+type apiKeyRecord struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	Scopes    []string  `json:"scopes"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// resolveAPIKey looks key up. It returns errInvalidAPIKey for keys that are
+// unknown, revoked or expired.
+func resolveAPIKey(ctx context.Context, redisClient *redis.Client, key string) (*apiKeyRecord, error) {
+	sum := sha256.Sum256([]byte(key))
+	raw, err := redisClient.Get(ctx, apiKeyRedisPrefix+hex.EncodeToString(sum[:])).Bytes()
+	if err == redis.Nil {
+		return nil, errInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	var record apiKeyRecord
+	if err := json.Unmarshal(raw, &record); err != nil {
+		return nil, err
+	}
+	if record.UserID == "" || time.Now().After(record.ExpiresAt) {
+		return nil, errInvalidAPIKey
+	}
+	return &record, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// ----------------------------------
+// Authentication policy
+// ----------------------------------
+
+// authLevel states what a route demands of the caller's credentials.
+type authLevel string
+
+const (
+	// authAnonymous ignores any credentials; no identity is forwarded.
+	authAnonymous authLevel = "anonymous"
+	// authOptional authenticates the caller when a token is present and lets
+	// the request through without an identity otherwise.
+	authOptional authLevel = "optional"
+	// authRequired rejects requests without a valid token.
+	authRequired authLevel = "required"
+)
+
+func (l *authLevel) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+	switch level := authLevel(s); level {
+	case authAnonymous, authOptional, authRequired:
+		*l = level
+		return nil
+	}
+	return fmt.Errorf("line %d: unknown auth level %q (want anonymous, optional or required)", value.Line, s)
+}
+
+// authRule overrides the route's auth level for matching paths and methods.
+// An empty Methods list matches every method.
+type authRule struct {
+	Path    string    `yaml:"path"`
+	Methods []string  `yaml:"methods"`
+	Auth    authLevel `yaml:"auth"`
+}
+
+// authFor returns the auth level of a request to the route: the first
+// matching rule wins, then the route's own level. Routes that do not state a
+// level require authentication.
+func (m *routeMapping) authFor(method, path string) authLevel {
+	for _, r := range m.AuthRules {
+		if pathMatches(r.Path, path) && methodMatches(r.Methods, method) {
+			return r.Auth
+		}
+	}
+	if m.Auth != "" {
+		return m.Auth
+	}
+	return authRequired
+}
+
+// validateAuthRules checks that every rule of the route can apply to some
+// request: its path must lie inside the route and its methods must be ones
+// the route allows.
+func (m *routeMapping) validateAuthRules() error {
+	for _, r := range m.AuthRules {
+		if r.Auth == "" {
+			return fmt.Errorf("route %s: auth rule %q has no auth level", m.Prefix, r.Path)
+		}
+		if !m.matches(strings.TrimSuffix(r.Path, "/*")) {
+			return fmt.Errorf("route %s: auth rule path %q is outside the route", m.Prefix, r.Path)
+		}
+		for _, method := range r.Methods {
+			if !methodMatches(m.Methods, method) {
+				return fmt.Errorf("route %s: auth rule %q names method %s, which the route does not allow", m.Prefix, r.Path, method)
+			}
+		}
+	}
+	return nil
+}
+
+// localAuthPolicies gives the auth level of the gateway's own endpoints, keyed
+// by their gin route pattern.
+type localAuthPolicies map[string]authLevel
+
+// check verifies at startup that every endpoint registered on the router has
+// a policy and that every policy belongs to a registered endpoint, so that no
+// endpoint silently falls back to a default.
+func (p localAuthPolicies) check(routes gin.RoutesInfo) error {
+	registered := make(map[string]bool)
+	var missing []string
+	for _, r := range routes {
+		registered[r.Path] = true
+		if _, ok := p[r.Path]; !ok {
+			missing = append(missing, r.Method+" "+r.Path)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("no auth policy for %s", strings.Join(missing, ", "))
+	}
+	for path := range p {
+		if !registered[path] {
+			return fmt.Errorf("auth policy for unregistered endpoint %s", path)
+		}
+	}
+	return nil
+}
+
+// authLevelFor returns the auth level of the current request. Requests that
+// match neither a gateway endpoint nor a route are treated as anonymous; they
+// are answered with 404 without reaching any service.
+func authLevelFor(c *gin.Context, local localAuthPolicies) authLevel {
+	if path := c.FullPath(); path != "" {
+		if level, ok := local[path]; ok {
+			return level
+		}
+		return authRequired
+	}
+	if route := routeFromContext(c); route != nil {
+		return route.authFor(c.Request.Method, c.Request.URL.Path)
+	}
+	return authAnonymous
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+)
+
+// ----------------------------------
+// Client-side load balancing
+// ----------------------------------
+
+const (
+	balancerRoundRobin       = "round_robin"
+	balancerLeastOutstanding = "least_outstanding"
+)
+
+// balancer picks the instance of a service that should receive the next
+// request. Pick returns nil when instances is empty.
+type balancer interface {
+	Pick(service string, instances []*upstreamInstance) *upstreamInstance
+}
+
+func newBalancer(strategy string) (balancer, error) {
+	switch strategy {
+	case "", balancerRoundRobin:
+		return &roundRobinBalancer{}, nil
+	case balancerLeastOutstanding:
+		return leastOutstandingBalancer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown load balancing strategy %q", strategy)
+	}
+}
+
+// roundRobinBalancer cycles through the instances of each service in turn.
+type roundRobinBalancer struct {
+	counters sync.Map // service name -> *atomic.Uint64
+}
+
+func (b *roundRobinBalancer) Pick(service string, instances []*upstreamInstance) *upstreamInstance {
+	if len(instances) == 0 {
+		return nil
+	}
+	v, _ := b.counters.LoadOrStore(service, new(atomic.Uint64))
+	n := v.(*atomic.Uint64).Add(1) - 1
+	return instances[n%uint64(len(instances))]
+}
+
+// leastOutstandingBalancer sends each request to the instance with the fewest
+// requests in flight. Ties are broken at random so that idle instances share
+// the load evenly.
+type leastOutstandingBalancer struct{}
+
+func (leastOutstandingBalancer) Pick(_ string, instances []*upstreamInstance) *upstreamInstance {
+	if len(instances) == 0 {
+		return nil
+	}
+	offset := rand.IntN(len(instances))
+	var best *upstreamInstance
+	for i := range instances {
+		inst := instances[(offset+i)%len(instances)]
+		if best == nil || inst.Outstanding() < best.Outstanding() {
+			best = inst
+		}
+	}
+	return best
+}
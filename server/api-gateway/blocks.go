@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+// ----------------------------------
+// Access lists and blocks
+// ----------------------------------
+
+type blockKind string
+
+const (
+	blockAllow blockKind = "allow" // IP range exempt from deny entries
+	blockDeny  blockKind = "deny"  // IP range whose requests are rejected
+	blockUser  blockKind = "user"  // user ID whose requests are rejected
+)
+
+const (
+	// blockKeyPrefix namespaces the entries in Redis; each entry is a key of
+	// its own that Redis expires with it.
+	blockKeyPrefix = "gateway:blocks:"
+	// blocksChangedChannel tells the gateway replicas to reload the entries.
+	blocksChangedChannel = "gateway:blocks:changed"
+)
+
+// block is an entry of the access lists. Value is a CIDR prefix for allow
+// and deny entries, and a user ID for user entries.
+type block struct {
+	Kind      blockKind `json:"type"`
+	Value     string    `json:"value"`
+	Reason    string    `json:"reason,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (b block) key() string {
+	return blockKeyPrefix + string(b.Kind) + ":" + b.Value
+}
+
+// normalize checks the entry and rewrites IP values as canonical prefixes,
+// so that 203.0.113.7 and 203.0.113.7/32 are the same entry.
+func (b *block) normalize() error {
+	switch b.Kind {
+	case blockAllow, blockDeny:
+		prefix, err := parseIPPrefix(b.Value)
+		if err != nil {
+			return err
+		}
+		b.Value = prefix.String()
+	case blockUser:
+		if b.Value == "" {
+			return errors.New("user ID must not be empty")
+		}
+	default:
+		return fmt.Errorf("type must be %s, %s or %s", blockAllow, blockDeny, blockUser)
+	}
+	return nil
+}
+
+// parseIPPrefix accepts a CIDR prefix or a single address.
+func parseIPPrefix(s string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("%q is neither an IP address nor a CIDR prefix", s)
+	}
+	return netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()).Masked(), nil
+}
+
+// blockList holds the access lists that all gateway replicas share through
+// Redis. Each replica checks requests against a copy it reloads every
+// refresh interval and whenever an entry changes; while Redis is unreachable
+// it keeps the last copy.
+type blockList struct {
+	client  *redis.Client
+	timeout time.Duration
+	logger  *logrus.Logger
+
+	mu     sync.RWMutex
+	blocks []block          // allow and deny entries, longest prefix first, then user entries
+	ranges []netip.Prefix   // prefixes of the allow and deny entries, by index
+	users  map[string]block // user ID -> entry
+}
+
+func newBlockList(client *redis.Client, logger *logrus.Logger) *blockList {
+	return &blockList{
+		client:  client,
+		timeout: 2 * time.Second,
+		logger:  logger,
+		users:   make(map[string]block),
+	}
+}
+
+// start loads the entries, then keeps reloading them every interval and on
+// every change announced on blocksChangedChannel.
+func (l *blockList) start(ctx context.Context, interval time.Duration) {
+	failing := false
+	reload := func() {
+		err := l.load(ctx)
+		switch {
+		case err != nil && !failing:
+			l.logger.WithError(err).Warn("Failed to load blocks from Redis; keeping the last ones loaded")
+		case err == nil && failing:
+			l.logger.Info("Blocks loaded from Redis again")
+		}
+		failing = err != nil
+	}
+	reload()
+
+	// Redis pub/sub resubscribes by itself after a lost connection
+	sub := l.client.Subscribe(ctx, blocksChangedChannel)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-sub.Channel():
+			case <-ctx.Done():
+				sub.Close()
+				return
+			}
+			reload()
+		}
+	}()
+}
+
+// load replaces the local copy with the entries in Redis.
+func (l *blockList) load(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, l.timeout)
+	defer cancel()
+
+	var keys []string
+	iter := l.client.Scan(ctx, 0, blockKeyPrefix+"*", 500).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	var values []any
+	if len(keys) > 0 {
+		var err error
+		if values, err = l.client.MGet(ctx, keys...).Result(); err != nil {
+			return err
+		}
+	}
+
+	var ranges, users []block
+	for i, v := range values {
+		raw, ok := v.(string)
+		if !ok {
+			continue // expired since the scan
+		}
+		var b block
+		if err := json.Unmarshal([]byte(raw), &b); err != nil || b.normalize() != nil {
+			l.logger.WithField("key", keys[i]).Warn("Ignoring malformed block")
+			continue
+		}
+		if b.Kind == blockUser {
+			users = append(users, b)
+		} else {
+			ranges = append(ranges, b)
+		}
+	}
+	l.replace(ranges, users)
+	return nil
+}
+
+func (l *blockList) replace(ranges, users []block) {
+	prefixes := make([]netip.Prefix, len(ranges))
+	for i := range ranges {
+		prefixes[i], _ = parseIPPrefix(ranges[i].Value)
+	}
+	// The longest matching prefix decides, so order by prefix length
+	idx := make([]int, len(ranges))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool { return prefixes[idx[a]].Bits() > prefixes[idx[b]].Bits() })
+
+	blocks := make([]block, 0, len(ranges)+len(users))
+	sorted := make([]netip.Prefix, len(ranges))
+	for i, j := range idx {
+		blocks = append(blocks, ranges[j])
+		sorted[i] = prefixes[j]
+	}
+	byUser := make(map[string]block, len(users))
+	for _, b := range users {
+		blocks = append(blocks, b)
+		byUser[b.Value] = b
+	}
+
+	l.mu.Lock()
+	l.blocks, l.ranges, l.users = blocks, sorted, byUser
+	l.mu.Unlock()
+}
+
+// add stores b in Redis until it expires, replacing an entry of the same
+// type and value, and has every replica reload.
+func (l *blockList) add(ctx context.Context, b block) error {
+	payload, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, l.timeout)
+	defer cancel()
+	if err := l.client.SetArgs(ctx, b.key(), payload, redis.SetArgs{ExpireAt: b.ExpiresAt}).Err(); err != nil {
+		return err
+	}
+	l.changed(ctx)
+	return nil
+}
+
+// remove deletes an entry, and reports whether there was one.
+func (l *blockList) remove(ctx context.Context, b block) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, l.timeout)
+	defer cancel()
+	n, err := l.client.Del(ctx, b.key()).Result()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	l.changed(ctx)
+	return true, nil
+}
+
+// changed reloads the entries here and on the other replicas. A replica
+// that misses the announcement catches up at its next refresh.
+func (l *blockList) changed(ctx context.Context) {
+	if err := l.load(ctx); err != nil {
+		l.logger.WithError(err).Warn("Failed to reload blocks")
+	}
+	if err := l.client.Publish(ctx, blocksChangedChannel, "").Err(); err != nil {
+		l.logger.WithError(err).Warn("Failed to announce changed blocks")
+	}
+}
+
+// list returns the entries in effect on this replica: allow and deny entries
+// in the order they are matched, then user entries.
+func (l *blockList) list() []block {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	now := time.Now()
+	blocks := make([]block, 0, len(l.blocks))
+	for _, b := range l.blocks {
+		if now.Before(b.ExpiresAt) {
+			blocks = append(blocks, b)
+		}
+	}
+	return blocks
+}
+
+// ipBlocked reports whether ip is denied: the longest allow or deny prefix
+// containing it decides, and addresses no entry contains are allowed.
+func (l *blockList) ipBlocked(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	now := time.Now()
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for i, prefix := range l.ranges {
+		if prefix.Contains(addr) && now.Before(l.blocks[i].ExpiresAt) {
+			return l.blocks[i].Kind == blockDeny
+		}
+	}
+	return false
+}
+
+func (l *blockList) userBlocked(userID string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	b, ok := l.users[userID]
+	return ok && time.Now().Before(b.ExpiresAt)
+}
+
+// blockMiddleware rejects requests from denied IPs and blocked users. It runs
+// after authentication, so that the caller's user ID is known, and before
+// rate limiting, so that blocked callers do not use up any limit.
+func blockMiddleware(blocks *blockList) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		blocked := blocks.ipBlocked(c.ClientIP())
+		if uid, ok := c.Get("user_id"); ok && !blocked {
+			blocked = blocks.userBlocked(fmt.Sprintf("%v", uid))
+		}
+		if blocked {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
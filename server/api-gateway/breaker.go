@@ -0,0 +1,201 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// ----------------------------------
+// Circuit breaker
+// ----------------------------------
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+type breakerConfig struct {
+	// FailureThreshold is the number of consecutive failures that opens the breaker.
+	FailureThreshold int
+	// OpenTimeout is how long the breaker rejects requests before it lets probes through.
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of probes allowed, and the number of
+	// successes required to close the breaker again, while half-open.
+	HalfOpenRequests int
+}
+
+// circuitBreaker tracks the health of a single upstream instance.
+//
+// A closed breaker lets every request through. After FailureThreshold
+// consecutive failures it opens and rejects requests for OpenTimeout. It then
+// becomes half-open and admits up to HalfOpenRequests probes: a failed probe
+// opens it again, and HalfOpenRequests successful probes close it.
+type circuitBreaker struct {
+	cfg breakerConfig
+
+	mu                sync.Mutex
+	state             breakerState
+	failures          int
+	openedAt          time.Time
+	halfOpenInFlight  int
+	halfOpenSuccesses int
+}
+
+func newCircuitBreaker(cfg breakerConfig) *circuitBreaker {
+	if cfg.FailureThreshold < 1 {
+		cfg.FailureThreshold = 1
+	}
+	if cfg.HalfOpenRequests < 1 {
+		cfg.HalfOpenRequests = 1
+	}
+	return &circuitBreaker{cfg: cfg}
+}
+
+// currentState moves an open breaker to half-open once OpenTimeout has passed.
+// The caller must hold b.mu.
+func (b *circuitBreaker) currentState(now time.Time) breakerState {
+	if b.state == breakerOpen && now.Sub(b.openedAt) >= b.cfg.OpenTimeout {
+		b.state = breakerHalfOpen
+		b.halfOpenInFlight = 0
+		b.halfOpenSuccesses = 0
+	}
+	return b.state
+}
+
+// Ready reports whether Allow would currently admit a request, without
+// reserving a half-open probe.
+func (b *circuitBreaker) Ready() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.currentState(time.Now()) {
+	case breakerOpen:
+		return false
+	case breakerHalfOpen:
+		return b.halfOpenInFlight < b.cfg.HalfOpenRequests
+	default:
+		return true
+	}
+}
+
+// Allow reports whether a request may be sent. Every admitted request must be
+// followed by exactly one call to Success, Failure or Ignore.
+func (b *circuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.currentState(time.Now()) {
+	case breakerOpen:
+		return false
+	case breakerHalfOpen:
+		if b.halfOpenInFlight >= b.cfg.HalfOpenRequests {
+			return false
+		}
+		b.halfOpenInFlight++
+		return true
+	default:
+		return true
+	}
+}
+
+// Success records a request that the upstream answered properly.
+func (b *circuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerHalfOpen:
+		b.releaseProbe()
+		b.halfOpenSuccesses++
+		if b.halfOpenSuccesses >= b.cfg.HalfOpenRequests {
+			b.state = breakerClosed
+			b.failures = 0
+		}
+	case breakerClosed:
+		b.failures = 0
+	}
+}
+
+// Failure records a request that the upstream failed to answer.
+func (b *circuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerHalfOpen:
+		b.releaseProbe()
+		b.trip(time.Now())
+	case breakerClosed:
+		b.failures++
+		if b.failures >= b.cfg.FailureThreshold {
+			b.trip(time.Now())
+		}
+	}
+}
+
+// Ignore releases an admitted request whose outcome says nothing about the
+// upstream, such as one the client cancelled.
+func (b *circuitBreaker) Ignore() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen {
+		b.releaseProbe()
+	}
+}
+
+// releaseProbe frees a half-open probe slot. Requests admitted before the
+// breaker opened may finish after it turned half-open, so the count is
+// clamped at zero. The caller must hold b.mu.
+func (b *circuitBreaker) releaseProbe() {
+	if b.halfOpenInFlight > 0 {
+		b.halfOpenInFlight--
+	}
+}
+
+// trip opens the breaker. The caller must hold b.mu.
+func (b *circuitBreaker) trip(now time.Time) {
+	b.state = breakerOpen
+	b.openedAt = now
+	b.failures = 0
+}
+
+// RetryAfter returns how long an open breaker keeps rejecting requests. ok
+// is false when the breaker is not open.
+func (b *circuitBreaker) RetryAfter() (wait time.Duration, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.currentState(time.Now()) != breakerOpen {
+		return 0, false
+	}
+	return b.cfg.OpenTimeout - time.Since(b.openedAt), true
+}
+
+type breakerSnapshot struct {
+	State      string `json:"state"`
+	Failures   int    `json:"consecutive_failures"`
+	RetryAfter string `json:"retry_after,omitempty"`
+}
+
+func (b *circuitBreaker) Snapshot() breakerSnapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	snap := breakerSnapshot{
+		State:    b.currentState(now).String(),
+		Failures: b.failures,
+	}
+	if b.state == breakerOpen {
+		snap.RetryAfter = (b.cfg.OpenTimeout - now.Sub(b.openedAt)).Round(time.Second).String()
+	}
+	return snap
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+)
+
+// ----------------------------------
+// Response compression
+// ----------------------------------
+
+const (
+	encodingBrotli = "br"
+	encodingGzip   = "gzip"
+)
+
+var (
+	gzipWriters   = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+	brotliWriters = sync.Pool{New: func() any { return brotli.NewWriterLevel(io.Discard, brotli.DefaultCompression) }}
+)
+
+// compressionMiddleware compresses JSON responses of at least minSize bytes
+// with brotli or gzip, whichever the client prefers. Streams, HEAD requests
+// and responses the upstream already encoded are passed through unchanged.
+func compressionMiddleware(minSize int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodHead || streamKindOf(c.Request) != streamNone {
+			c.Next()
+			return
+		}
+
+		w := &compressWriter{ResponseWriter: c.Writer, encoding: negotiateEncoding(c.Request.Header), minSize: minSize}
+		c.Writer = w
+		// A panic leaves the response to the recovery handler, which must not
+		// write into the buffer
+		defer func() { c.Writer = w.ResponseWriter }()
+		c.Next()
+		w.finish()
+	}
+}
+
+// negotiateEncoding returns the encoding with the highest quality in the
+// Accept-Encoding header, preferring brotli on a tie, or "" when the client
+// accepts neither.
+func negotiateEncoding(h http.Header) string {
+	quality := map[string]float64{}
+	for _, v := range h.Values("Accept-Encoding") {
+		for _, part := range strings.Split(v, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			q := 1.0
+			if qv, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if f, err := strconv.ParseFloat(qv, 64); err == nil {
+					q = f
+				}
+			}
+			quality[strings.ToLower(strings.TrimSpace(name))] = q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, enc := range []string{encodingBrotli, encodingGzip} {
+		q, ok := quality[enc]
+		if !ok {
+			q, ok = quality["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// isJSON reports whether the response body is JSON.
+func isJSON(h http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// compressWriter holds back the headers until it has seen minSize bytes of
+// the body, or all of it, and then either compresses the rest of the body or
+// passes it through.
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding string // negotiated with the client; "" when it accepts neither
+	minSize  int
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     io.WriteCloser // nil when the response is not compressed
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if !w.decided {
+		w.status = code
+	}
+}
+
+func (w *compressWriter) WriteHeaderNow() {
+	if !w.decided {
+		w.commit()
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) < w.minSize {
+			return len(p), nil
+		}
+		if err := w.commit(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if w.enc != nil {
+		return w.enc.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *compressWriter) Status() int {
+	if w.decided {
+		return w.ResponseWriter.Status()
+	}
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *compressWriter) Written() bool {
+	return w.decided || len(w.buf) > 0
+}
+
+// Flush is ignored until the encoding is decided, since the little that is
+// held back until then is not worth sending on its own.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		return
+	}
+	if f, ok := w.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// commit settles the encoding from the headers and the body seen so far,
+// and writes out the headers and the buffered body.
+func (w *compressWriter) commit() error {
+	w.decided = true
+	h := w.Header()
+
+	// The response depends on Accept-Encoding whether or not this one is
+	// compressed, and a 304 carries the Vary of the response it stands for
+	if isJSON(h) || w.status == http.StatusNotModified {
+		if !headerHasToken(h, "Vary", "Accept-Encoding") {
+			h.Add("Vary", "Accept-Encoding")
+		}
+	}
+	if w.encoding != "" && len(w.buf) > 0 && len(w.buf) >= w.minSize && isJSON(h) && h.Get("Content-Encoding") == "" {
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		// A strong validator identifies one encoding of the body
+		if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			h.Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+w.encoding+`"`)
+		}
+		w.enc = newEncoder(w.encoding, w.ResponseWriter)
+	}
+
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		w.ResponseWriter.WriteHeaderNow()
+		return nil
+	}
+	if w.enc != nil {
+		_, err := w.enc.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// finish writes out a body shorter than minSize and completes a compressed
+// one.
+func (w *compressWriter) finish() {
+	if !w.decided {
+		w.commit()
+	}
+	if w.enc != nil {
+		w.enc.Close()
+		w.enc = nil
+	}
+}
+
+// pooledEncoder returns its compressor to the pool once closed.
+type pooledEncoder struct {
+	io.WriteCloser
+	pool *sync.Pool
+}
+
+func (e *pooledEncoder) Flush() error {
+	return e.WriteCloser.(interface{ Flush() error }).Flush()
+}
+
+func (e *pooledEncoder) Close() error {
+	err := e.WriteCloser.Close()
+	e.pool.Put(e.WriteCloser)
+	return err
+}
+
+func newEncoder(encoding string, dst io.Writer) io.WriteCloser {
+	if encoding == encodingBrotli {
+		bw := brotliWriters.Get().(*brotli.Writer)
+		bw.Reset(dst)
+		return &pooledEncoder{WriteCloser: bw, pool: &brotliWriters}
+	}
+	gw := gzipWriters.Get().(*gzip.Writer)
+	gw.Reset(dst)
+	return &pooledEncoder{WriteCloser: gw, pool: &gzipWriters}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ----------------------------------
+// Conditional GET
+// ----------------------------------
+
+// validator is the ETag of a response that is fresh until expires, along with
+// the headers a 304 in its place must repeat and the surrogate keys the
+// response was tagged with.
+type validator struct {
+	etag    string
+	header  http.Header
+	tags    []string
+	expires time.Time
+}
+
+// validatorCache remembers the ETags of fresh responses, so that a request
+// whose If-None-Match still matches can be answered without the upstream.
+// Entries are kept by path and then by query and caller, so that a write to
+// a path drops every variant of it.
+type validatorCache struct {
+	mu         sync.Mutex
+	paths      map[string]map[string]validator
+	entries    int
+	maxEntries int
+}
+
+func newValidatorCache(maxEntries int) *validatorCache {
+	return &validatorCache{paths: make(map[string]map[string]validator), maxEntries: maxEntries}
+}
+
+func (vc *validatorCache) get(path, variant string) (validator, bool) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	v, ok := vc.paths[path][variant]
+	if !ok {
+		return validator{}, false
+	}
+	if !time.Now().Before(v.expires) {
+		vc.deleteLocked(path, variant)
+		return validator{}, false
+	}
+	return v, true
+}
+
+// put stores v, unless the cache is full of entries that are still fresh.
+func (vc *validatorCache) put(path, variant string, v validator) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	if _, ok := vc.paths[path][variant]; !ok && vc.entries >= vc.maxEntries {
+		vc.evictExpiredLocked()
+		if vc.entries >= vc.maxEntries {
+			return
+		}
+	}
+	variants := vc.paths[path]
+	if variants == nil {
+		variants = make(map[string]validator)
+		vc.paths[path] = variants
+	}
+	if _, ok := variants[variant]; !ok {
+		vc.entries++
+	}
+	variants[variant] = v
+}
+
+// invalidate drops every validator of path.
+func (vc *validatorCache) invalidate(path string) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	vc.entries -= len(vc.paths[path])
+	delete(vc.paths, path)
+}
+
+// purge drops the validators of responses tagged with one of tags.
+func (vc *validatorCache) purge(tags map[string]bool) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	for path, variants := range vc.paths {
+		for variant, v := range variants {
+			for _, t := range v.tags {
+				if tags[t] {
+					vc.deleteLocked(path, variant)
+					break
+				}
+			}
+		}
+	}
+}
+
+func (vc *validatorCache) deleteLocked(path, variant string) {
+	if _, ok := vc.paths[path][variant]; !ok {
+		return
+	}
+	delete(vc.paths[path], variant)
+	vc.entries--
+	if len(vc.paths[path]) == 0 {
+		delete(vc.paths, path)
+	}
+}
+
+func (vc *validatorCache) evictExpiredLocked() {
+	now := time.Now()
+	for path, variants := range vc.paths {
+		for variant, v := range variants {
+			if !now.Before(v.expires) {
+				vc.deleteLocked(path, variant)
+			}
+		}
+	}
+}
+
+// conditionalGetMiddleware gives GET responses of up to maxBody bytes a strong
+// ETag, unless the upstream set one, and answers a matching If-None-Match
+// with 304. While a response is fresh by its Cache-Control, its ETag is
+// remembered and a matching request does not reach the upstream at all. A
+// successful write to a path forgets the ETags of that path.
+func conditionalGetMiddleware(cache *validatorCache, maxBody int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if c.Request.Method != http.MethodGet {
+			c.Next()
+			if !isSafeMethod(c.Request.Method) && c.Writer.Status() < http.StatusBadRequest {
+				cache.invalidate(path)
+			}
+			return
+		}
+		if streamKindOf(c.Request) != streamNone {
+			c.Next()
+			return
+		}
+
+		// Responses may depend on the caller, so callers never share validators
+		variant := c.Request.URL.RawQuery
+		if uid, ok := c.Get("user_id"); ok {
+			variant += fmt.Sprintf("\x00%v", uid)
+		}
+		ifNoneMatch := c.GetHeader("If-None-Match")
+		if ifNoneMatch != "" {
+			if v, ok := cache.get(path, variant); ok {
+				if tag, ok := matchingETag(ifNoneMatch, v.etag); ok {
+					for k, vs := range v.header {
+						c.Writer.Header()[k] = vs
+					}
+					c.Header("ETag", tag)
+					c.Status(http.StatusNotModified)
+					c.Writer.WriteHeaderNow()
+					c.Abort()
+					return
+				}
+			}
+		}
+
+		w := &etagWriter{ResponseWriter: c.Writer, limit: maxBody}
+		c.Writer = w
+		defer func() { c.Writer = w.ResponseWriter }()
+		c.Next()
+
+		if w.overflowed || w.Status() != http.StatusOK || hasCacheDirective(w.Header(), "no-store") {
+			w.flush()
+			return
+		}
+		h := w.Header()
+		etag := h.Get("ETag")
+		if etag == "" {
+			sum := sha256.Sum256(w.buf)
+			etag = `"` + base64.RawURLEncoding.EncodeToString(sum[:18]) + `"`
+			h.Set("ETag", etag)
+		}
+		if ttl := sharedFreshness(h); ttl > 0 {
+			cache.put(path, variant, validator{
+				etag:    etag,
+				header:  notModifiedHeader(h),
+				tags:    c.GetStringSlice("surrogate_keys"),
+				expires: time.Now().Add(ttl),
+			})
+		}
+		if tag, ok := matchingETag(ifNoneMatch, etag); ok {
+			for k := range h {
+				if _, keep := notModifiedHeaders[k]; !keep {
+					h.Del(k)
+				}
+			}
+			h.Set("ETag", tag)
+			w.buf = nil
+			w.status = http.StatusNotModified
+		}
+		w.flush()
+	}
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions || method == http.MethodTrace
+}
+
+// notModifiedHeaders are the headers a 304 repeats from the response it
+// stands for.
+var notModifiedHeaders = map[string]struct{}{
+	"Cache-Control":    {},
+	"Content-Location": {},
+	"Date":             {},
+	"Expires":          {},
+	"Vary":             {},
+}
+
+func notModifiedHeader(h http.Header) http.Header {
+	kept := make(http.Header)
+	for k := range notModifiedHeaders {
+		if vs, ok := h[k]; ok {
+			kept[k] = append([]string(nil), vs...)
+		}
+	}
+	return kept
+}
+
+// matchingETag returns the entity tag in an If-None-Match header that
+// matches etag, strongly. Tags the compression middleware derived from etag
+// for an encoding of the body match too, and the client's own tag is the one
+// returned, so that it keeps describing the body the client holds.
+func matchingETag(ifNoneMatch, etag string) (string, bool) {
+	if ifNoneMatch == "" || !strings.HasPrefix(etag, `"`) {
+		return "", false
+	}
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return etag, true
+	}
+	base := strings.TrimSuffix(etag, `"`)
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		switch tag {
+		case etag, base + "-" + encodingBrotli + `"`, base + "-" + encodingGzip + `"`:
+			return tag, true
+		}
+	}
+	return "", false
+}
+
+// sharedFreshness returns how long a shared cache may still consider the
+// response fresh, or zero when it must not be stored or reused without
+// revalidation.
+func sharedFreshness(h http.Header) time.Duration {
+	// Validators are not kept per request header, other than the encoding
+	for _, v := range h.Values("Vary") {
+		for _, field := range strings.Split(v, ",") {
+			if field = strings.TrimSpace(field); field != "" && !strings.EqualFold(field, "Accept-Encoding") {
+				return 0
+			}
+		}
+	}
+	fresh, _, ok := sharedCacheLifetime(h)
+	if !ok {
+		return 0
+	}
+	// A response served from the response cache has already aged
+	age, _ := strconv.Atoi(h.Get("Age"))
+	return max(fresh-time.Duration(age)*time.Second, 0)
+}
+
+// hasCacheDirective reports whether the Cache-Control header has directive.
+func hasCacheDirective(h http.Header, directive string) bool {
+	_, ok := parseCacheControl(h)[directive]
+	return ok
+}
+
+// etagWriter holds back a response until it is complete, so that its ETag can
+// be computed. A body larger than limit is passed through without one.
+type etagWriter struct {
+	gin.ResponseWriter
+	limit int
+
+	status     int
+	buf        []byte
+	overflowed bool
+}
+
+func (w *etagWriter) WriteHeader(code int) {
+	if !w.overflowed {
+		w.status = code
+	}
+}
+
+// WriteHeaderNow is deferred to flush, where the status may still become 304.
+func (w *etagWriter) WriteHeaderNow() {}
+
+func (w *etagWriter) Write(p []byte) (int, error) {
+	if w.overflowed {
+		return w.ResponseWriter.Write(p)
+	}
+	if len(w.buf)+len(p) <= w.limit {
+		w.buf = append(w.buf, p...)
+		return len(p), nil
+	}
+	w.overflowed = true
+	if err := w.flush(); err != nil {
+		return 0, err
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *etagWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *etagWriter) Status() int {
+	if w.overflowed {
+		return w.ResponseWriter.Status()
+	}
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *etagWriter) Written() bool {
+	return w.overflowed || len(w.buf) > 0
+}
+
+// Flush is ignored while the body is held back.
+func (w *etagWriter) Flush() {
+	if w.overflowed {
+		w.ResponseWriter.Flush()
+	}
+}
+
+// flush writes out the status and the body held back.
+func (w *etagWriter) flush() error {
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		w.ResponseWriter.WriteHeaderNow()
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
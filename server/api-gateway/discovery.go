@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ----------------------------------
+// Service discovery
+// ----------------------------------
+
+type serviceInfo struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Version string `json:"version"`
+}
+
+type registryResponse struct {
+	Status int           `json:"status"`
+	Data   []serviceInfo `json:"data"`
+}
+
+// upstreamInstance is a single live instance of a registered service. The
+// same value is kept across registry refreshes for as long as the instance
+// stays registered at the same address and version, so per-instance state
+// such as the number of outstanding requests survives a refresh.
+type upstreamInstance struct {
+	ID      string
+	Service string
+	Address string
+	Version string // release tag from registration; empty when untagged
+
+	inflight atomic.Int64
+	breaker  *circuitBreaker
+	health   instanceHealth
+	mode     atomic.Int32 // instanceMode
+
+	// Reverse proxies with pooled connections, by route transport settings
+	proxiesMu sync.Mutex
+	proxies   map[proxyKey]*instanceProxy
+}
+
+// instanceMode is set by operators through the admin API. A draining
+// instance gets no new requests but finishes those in flight; a disabled one
+// is also no longer health checked and its idle connections are closed.
+type instanceMode int32
+
+const (
+	instanceActive instanceMode = iota
+	instanceDraining
+	instanceDisabled
+)
+
+func (m instanceMode) String() string {
+	switch m {
+	case instanceDraining:
+		return "draining"
+	case instanceDisabled:
+		return "disabled"
+	default:
+		return "active"
+	}
+}
+
+// Mode returns the operator-set mode of the instance.
+func (u *upstreamInstance) Mode() instanceMode {
+	return instanceMode(u.mode.Load())
+}
+
+// Outstanding returns the number of requests currently proxied to the instance.
+func (u *upstreamInstance) Outstanding() int64 {
+	return u.inflight.Load()
+}
+
+// acquire marks the start of a proxied request and returns the function that
+// marks its end.
+func (u *upstreamInstance) acquire() func() {
+	u.inflight.Add(1)
+	return func() { u.inflight.Add(-1) }
+}
+
+type Discovery struct {
+	registryURL     string
+	refreshInterval time.Duration
+	breakerCfg      breakerConfig
+
+	mu       sync.RWMutex
+	services map[string][]*upstreamInstance // name -> live instances
+	modes    map[string]instanceMode        // service/id -> mode other than active
+	logger   *logrus.Logger
+}
+
+func NewDiscovery(registryURL string, refreshInterval time.Duration, breakerCfg breakerConfig, logger *logrus.Logger) *Discovery {
+	d := &Discovery{
+		registryURL:     strings.TrimSuffix(registryURL, "/"),
+		refreshInterval: refreshInterval,
+		breakerCfg:      breakerCfg,
+		services:        make(map[string][]*upstreamInstance),
+		modes:           make(map[string]instanceMode),
+		logger:          logger,
+	}
+	go d.refreshLoop()
+	return d
+}
+
+func (d *Discovery) refreshLoop() {
+	ticker := time.NewTicker(d.refreshInterval)
+	for {
+		d.refresh()
+		<-ticker.C
+	}
+}
+
+// refresh replaces the instances with those currently in the registry. On
+// failure the previous instances are kept.
+func (d *Discovery) refresh() error {
+	resp, err := http.Get(fmt.Sprintf("%s/services", d.registryURL))
+	if err != nil {
+		d.logger.WithError(err).Warn("Failed to fetch services from registry")
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		d.logger.Warnf("Unexpected status from registry: %d", resp.StatusCode)
+		return fmt.Errorf("registry returned %d", resp.StatusCode)
+	}
+
+	var rr registryResponse
+	if err := json.NewDecoder(resp.Body).Decode(&rr); err != nil {
+		d.logger.WithError(err).Warn("Failed to decode registry response")
+		return err
+	}
+
+	d.mu.Lock()
+	previous := make(map[string]*upstreamInstance)
+	for _, instances := range d.services {
+		for _, inst := range instances {
+			previous[inst.Service+"/"+inst.ID] = inst
+		}
+	}
+
+	m := make(map[string][]*upstreamInstance)
+	for _, svc := range rr.Data {
+		id := svc.ID
+		if id == "" {
+			// Registries that predate instance IDs list one entry per name.
+			id = svc.Name
+		}
+		addr := strings.TrimSuffix(svc.Address, "/")
+
+		key := svc.Name + "/" + id
+		inst, ok := previous[key]
+		if ok && inst.Address == addr && inst.Version == svc.Version {
+			delete(previous, key)
+		} else {
+			inst = &upstreamInstance{
+				ID:      id,
+				Service: svc.Name,
+				Address: addr,
+				Version: svc.Version,
+				breaker: newCircuitBreaker(d.breakerCfg),
+			}
+			// A mode set by an operator outlives a change of address
+			inst.mode.Store(int32(d.modes[key]))
+		}
+		m[svc.Name] = append(m[svc.Name], inst)
+	}
+
+	// Keep a stable order so that round-robin walks instances predictably.
+	for _, instances := range m {
+		sort.Slice(instances, func(i, j int) bool { return instances[i].ID < instances[j].ID })
+	}
+
+	d.services = m
+	d.mu.Unlock()
+
+	// Instances that left or moved keep serving the requests in flight, but
+	// their idle connections are no longer needed
+	for _, inst := range previous {
+		inst.closeIdleConnections()
+	}
+
+	d.logger.Debugf("Service registry refreshed: %d services, %d instances", len(m), len(rr.Data))
+	return nil
+}
+
+// Instances returns the live instances of the named service.
+func (d *Discovery) Instances(name string) []*upstreamInstance {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	instances := make([]*upstreamInstance, len(d.services[name]))
+	copy(instances, d.services[name])
+	return instances
+}
+
+// GetAllServices returns the addresses of every live instance, by service name.
+func (d *Discovery) GetAllServices() map[string][]string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	services := make(map[string][]string)
+	for name, instances := range d.services {
+		for _, inst := range instances {
+			services[name] = append(services[name], inst.Address)
+		}
+	}
+	return services
+}
+
+// AvailableInstances returns the live instances of the named service that
+// are active, pass their health checks and whose circuit breaker currently
+// admits requests.
+func (d *Discovery) AvailableInstances(name string) []*upstreamInstance {
+	instances := d.Instances(name)
+	available := instances[:0]
+	for _, inst := range instances {
+		if inst.Mode() == instanceActive && inst.health.Healthy() && inst.breaker.Ready() {
+			available = append(available, inst)
+		}
+	}
+	return available
+}
+
+// SetMode sets the mode of an instance, and returns the instance or nil if
+// no such instance is registered.
+func (d *Discovery) SetMode(service, id string, mode instanceMode) *upstreamInstance {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var inst *upstreamInstance
+	for _, i := range d.services[service] {
+		if i.ID == id {
+			inst = i
+		}
+	}
+	if inst == nil {
+		return nil
+	}
+
+	key := service + "/" + id
+	if mode == instanceActive {
+		delete(d.modes, key)
+	} else {
+		d.modes[key] = mode
+	}
+	inst.mode.Store(int32(mode))
+	if mode == instanceDisabled {
+		inst.closeIdleConnections()
+	}
+	return inst
+}
+
+// HealthStatus reports the health checks of every instance, by service and
+// instance ID.
+func (d *Discovery) HealthStatus() map[string]map[string]healthSnapshot {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	status := make(map[string]map[string]healthSnapshot, len(d.services))
+	for name, instances := range d.services {
+		status[name] = make(map[string]healthSnapshot, len(instances))
+		for _, inst := range instances {
+			status[name][inst.ID] = inst.health.snapshot(inst.Address)
+		}
+	}
+	return status
+}
+
+// serviceBreakerStatus summarises the breakers of all instances of a service.
+type serviceBreakerStatus struct {
+	State     string                     `json:"state"`
+	Instances map[string]breakerSnapshot `json:"instances"`
+}
+
+// BreakerStatus reports the circuit breaker of every instance, by service. A
+// service is open when none of its instances admits requests, half-open when
+// only probes get through, and closed otherwise.
+func (d *Discovery) BreakerStatus() map[string]serviceBreakerStatus {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	status := make(map[string]serviceBreakerStatus, len(d.services))
+	for name, instances := range d.services {
+		s := serviceBreakerStatus{State: breakerOpen.String(), Instances: make(map[string]breakerSnapshot)}
+		for _, inst := range instances {
+			snap := inst.breaker.Snapshot()
+			s.Instances[inst.ID] = snap
+			switch {
+			case snap.State == breakerClosed.String():
+				s.State = breakerClosed.String()
+			case snap.State == breakerHalfOpen.String() && s.State == breakerOpen.String():
+				s.State = breakerHalfOpen.String()
+			}
+		}
+		status[name] = s
+	}
+	return status
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// ----------------------------------
+// API documentation
+// ----------------------------------
+
+// openAPIDocument is the part of an OpenAPI 3 document the gateway merges.
+// Operations and schemas are kept as decoded JSON, since the gateway only
+// moves them around.
+type openAPIDocument struct {
+	OpenAPI    string                               `json:"openapi"`
+	Info       map[string]any                       `json:"info"`
+	Paths      map[string]map[string]map[string]any `json:"paths"`
+	Components openAPIComponents                    `json:"components"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]any `json:"schemas,omitempty"`
+	SecuritySchemes map[string]any `json:"securitySchemes,omitempty"`
+}
+
+const (
+	schemaRefPrefix = "#/components/schemas/"
+	bearerAuthName  = "bearerAuth"
+	apiKeyAuthName  = "apiKeyAuth"
+)
+
+// serviceDoc is the last document fetched from a service, with its schemas
+// already renamed into the service's namespace.
+type serviceDoc struct {
+	doc       *openAPIDocument
+	fetchedAt time.Time
+}
+
+// apiDocs merges the OpenAPI documents the services publish into one
+// document of the public API. Each service's document is fetched from one
+// of its available instances and refetched once older than ttl; when a
+// fetch fails, the last document fetched is kept.
+type apiDocs struct {
+	disc    *Discovery
+	routes  *routeStore
+	client  *http.Client
+	ttl     time.Duration
+	version string
+	logger  *logrus.Logger
+
+	mu   sync.RWMutex
+	docs map[string]*serviceDoc
+}
+
+func newAPIDocs(disc *Discovery, routes *routeStore, ttl time.Duration, version string, logger *logrus.Logger) *apiDocs {
+	return &apiDocs{
+		disc:    disc,
+		routes:  routes,
+		client:  &http.Client{Timeout: 5 * time.Second},
+		ttl:     ttl,
+		version: version,
+		logger:  logger,
+		docs:    make(map[string]*serviceDoc),
+	}
+}
+
+// start refreshes the documents every interval until the process exits, so
+// that request validation and the merged document find them without waiting
+// for a fetch.
+func (d *apiDocs) start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		for {
+			d.refresh(context.Background(), d.routes.Load())
+			<-ticker.C
+		}
+	}()
+}
+
+// refresh refetches the documents of the services that are missing or
+// older than ttl.
+func (d *apiDocs) refresh(ctx context.Context, table *routeTable) {
+	var stale []string
+	d.mu.RLock()
+	for _, service := range table.services() {
+		if sd, ok := d.docs[service]; !ok || time.Since(sd.fetchedAt) >= d.ttl {
+			stale = append(stale, service)
+		}
+	}
+	d.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, service := range stale {
+		path := table.docsPath(service)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			doc, err := d.fetch(ctx, service, path)
+			if err != nil {
+				d.logger.WithError(err).WithField("service", service).Warn("Failed to fetch API documentation")
+				return
+			}
+			d.mu.Lock()
+			d.docs[service] = &serviceDoc{doc: doc, fetchedAt: time.Now()}
+			d.mu.Unlock()
+		}()
+	}
+	wg.Wait()
+}
+
+// fetch gets the document of service from the first available instance
+// that serves it.
+func (d *apiDocs) fetch(ctx context.Context, service, path string) (*openAPIDocument, error) {
+	instances := d.disc.AvailableInstances(service)
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("no available instances")
+	}
+	var lastErr error
+	for _, inst := range instances {
+		doc, err := d.fetchFrom(ctx, inst.Address+path)
+		if err == nil {
+			namespaceSchemas(doc, service)
+			return doc, nil
+		}
+		lastErr = fmt.Errorf("instance %s: %w", inst.ID, err)
+	}
+	return nil, lastErr
+}
+
+func (d *apiDocs) fetchFrom(ctx context.Context, url string) (*openAPIDocument, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	var doc openAPIDocument
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	return &doc, nil
+}
+
+// namespaceSchemas prefixes the schema names of doc with the service name,
+// so that models of the same name in different services stay apart.
+func namespaceSchemas(doc *openAPIDocument, service string) {
+	rename := func(name string) string { return service + "." + name }
+	schemas := make(map[string]any, len(doc.Components.Schemas))
+	for name, schema := range doc.Components.Schemas {
+		schemas[rename(name)] = schema
+	}
+	doc.Components.Schemas = schemas
+	renameSchemaRefs(doc.Components.Schemas, rename)
+	for _, item := range doc.Paths {
+		renameSchemaRefs(item, rename)
+	}
+}
+
+func renameSchemaRefs(v any, rename func(string) string) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			if ref, ok := child.(string); ok && k == "$ref" && strings.HasPrefix(ref, schemaRefPrefix) {
+				v[k] = schemaRefPrefix + rename(strings.TrimPrefix(ref, schemaRefPrefix))
+				continue
+			}
+			renameSchemaRefs(child, rename)
+		}
+	case map[string]map[string]any:
+		for _, child := range v {
+			renameSchemaRefs(child, rename)
+		}
+	case []any:
+		for _, child := range v {
+			renameSchemaRefs(child, rename)
+		}
+	}
+}
+
+// merge builds the public document from the service documents fetched so
+// far. Each route publishes the operations under its rewrite prefix at its
+// own prefix, limited to its methods and with the security its auth level
+// demands; operations that a longer prefix would take over are left out.
+func (d *apiDocs) merge(table *routeTable) *openAPIDocument {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	merged := &openAPIDocument{
+		OpenAPI: "3.0.3",
+		Info: map[string]any{
+			"title":   "Blogging Platform API",
+			"version": d.version,
+		},
+		Paths: make(map[string]map[string]map[string]any),
+		Components: openAPIComponents{
+			Schemas: make(map[string]any),
+			SecuritySchemes: map[string]any{
+				bearerAuthName: map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				apiKeyAuthName: map[string]any{
+					"type":        "apiKey",
+					"in":          "header",
+					"name":        "Authorization",
+					"description": `An API key, sent as "ApiKey <key>"`,
+				},
+			},
+		},
+	}
+	var missing []string
+	for _, service := range table.services() {
+		sd, ok := d.docs[service]
+		if !ok {
+			missing = append(missing, service)
+			continue
+		}
+		for name, schema := range sd.doc.Components.Schemas {
+			merged.Components.Schemas[name] = schema
+		}
+		for _, r := range table.routes {
+			if r.ServiceName == service {
+				mergeRoute(merged, r, table, sd.doc)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		merged.Info["description"] = "Services whose documentation could not be fetched: " + strings.Join(missing, ", ") + "."
+	}
+	return merged
+}
+
+func mergeRoute(merged *openAPIDocument, r *routeMapping, table *routeTable, doc *openAPIDocument) {
+	rewrite := strings.TrimSuffix(r.RewritePrefix, "/")
+	for path, item := range doc.Paths {
+		if path != rewrite && !strings.HasPrefix(path, rewrite+"/") {
+			continue
+		}
+		public := r.Prefix + strings.TrimPrefix(path, rewrite)
+		if table.match(public) != r {
+			continue
+		}
+		for method, op := range item {
+			upper := strings.ToUpper(method)
+			if !isHTTPMethod(upper) || !methodMatches(r.Methods, upper) {
+				continue
+			}
+			published := make(map[string]any, len(op)+2)
+			for k, v := range op {
+				published[k] = v
+			}
+			published["tags"] = []string{r.ServiceName}
+			switch r.authFor(upper, public) {
+			case authAnonymous:
+				delete(published, "security")
+			case authOptional:
+				published["security"] = []map[string][]string{{}, {bearerAuthName: {}}, {apiKeyAuthName: {}}}
+			default:
+				published["security"] = []map[string][]string{{bearerAuthName: {}}, {apiKeyAuthName: {}}}
+			}
+			if merged.Paths[public] == nil {
+				merged.Paths[public] = make(map[string]map[string]any)
+			}
+			merged.Paths[public][method] = published
+		}
+	}
+}
+
+// operation returns the operation of service's document that serves method
+// on the upstream path, and the document's schemas. Paths with more literal
+// segments win over templated ones. It reports false when the document has
+// not been fetched or has no such operation.
+func (d *apiDocs) operation(service, method, path string) (op map[string]any, schemas map[string]any, ok bool) {
+	d.mu.RLock()
+	sd, found := d.docs[service]
+	d.mu.RUnlock()
+	if !found {
+		return nil, nil, false
+	}
+
+	best := -1
+	for template, item := range sd.doc.Paths {
+		literals, matched := matchPathTemplate(template, path)
+		if !matched || literals <= best {
+			continue
+		}
+		if candidate, exists := item[strings.ToLower(method)]; exists {
+			op, best = candidate, literals
+		}
+	}
+	return op, sd.doc.Components.Schemas, best >= 0
+}
+
+// matchPathTemplate reports whether path matches an OpenAPI path template
+// such as /post/{id}, and how many of the template's segments are literal.
+func matchPathTemplate(template, path string) (literals int, ok bool) {
+	tsegs := strings.Split(strings.Trim(template, "/"), "/")
+	psegs := strings.Split(strings.Trim(path, "/"), "/")
+	if len(tsegs) != len(psegs) {
+		return 0, false
+	}
+	for i, seg := range tsegs {
+		switch {
+		case strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}"):
+			if psegs[i] == "" {
+				return 0, false
+			}
+		case seg == psegs[i]:
+			literals++
+		default:
+			return 0, false
+		}
+	}
+	return literals, true
+}
+
+// documentHandler serves the merged document from the documents fetched so
+// far. It never waits for a service: start keeps them fetched.
+func (d *apiDocs) documentHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, d.merge(d.routes.Load()))
+	}
+}
+
+// uiHandler serves Swagger UI, loaded from a CDN, browsing the merged
+// document.
+func (d *apiDocs) uiHandler(documentURL string) gin.HandlerFunc {
+	page := fmt.Sprintf(docsPage, documentURL)
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(page))
+	}
+}
+
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Blogging Platform API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({url: %q, dom_id: "#swagger-ui"});
+  </script>
+</body>
+</html>
+`
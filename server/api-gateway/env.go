@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// envInt reads an integer setting from the environment, falling back to def
+// when the variable is unset or malformed.
+func envInt(logger *logrus.Logger, key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		logger.WithError(err).Warnf("Invalid %s, using default %d", key, def)
+		return def
+	}
+	return n
+}
+
+// envDuration reads a duration setting such as "30s" from the environment,
+// falling back to def when the variable is unset or malformed.
+func envDuration(logger *logrus.Logger, key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		logger.WithError(err).Warnf("Invalid %s, using default %s", key, def)
+		return def
+	}
+	return d
+}
+
+// envFloat reads a floating point setting from the environment, falling back
+// to def when the variable is unset or malformed.
+func envFloat(logger *logrus.Logger, key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		logger.WithError(err).Warnf("Invalid %s, using default %g", key, def)
+		return def
+	}
+	return f
+}
+
+// envList reads a comma-separated setting from the environment, or nil when
+// the variable is unset.
+func envList(key string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ----------------------------------
+// Fault injection
+// ----------------------------------
+
+// faultRule injects a fault into a percentage of the requests it matches:
+// it delays them, answers them with an error status, or drops the client's
+// connection. A delay may be combined with either of the others; a delayed
+// request that is neither answered nor dropped is then served as usual.
+type faultRule struct {
+	ID        int           `json:"id"`
+	Service   string        `json:"service,omitempty"` // requests routed to the service
+	Path      string        `json:"path,omitempty"`    // public path; a trailing /* matches everything below
+	Methods   []string      `json:"methods,omitempty"`
+	Header    *valueMatch   `json:"header,omitempty"`
+	Percent   float64       `json:"percent"`
+	Delay     time.Duration `json:"-"`
+	Status    int           `json:"status,omitempty"`
+	Abort     bool          `json:"abort,omitempty"`
+	ExpiresAt time.Time     `json:"expires_at"`
+}
+
+// MarshalJSON writes the delay as a duration string such as "2s".
+func (r *faultRule) MarshalJSON() ([]byte, error) {
+	type rule faultRule
+	var delay string
+	if r.Delay > 0 {
+		delay = r.Delay.String()
+	}
+	return json.Marshal(struct {
+		*rule
+		Delay string `json:"delay,omitempty"`
+	}{(*rule)(r), delay})
+}
+
+func (r *faultRule) validate() error {
+	switch {
+	case r.Percent <= 0 || r.Percent > 100:
+		return errors.New("percent must be above 0 and at most 100")
+	case r.Delay < 0:
+		return errors.New("delay must not be negative")
+	case r.Status != 0 && (r.Status < 400 || r.Status > 599):
+		return errors.New("status must be an error status between 400 and 599")
+	case r.Status != 0 && r.Abort:
+		return errors.New("status and abort are exclusive")
+	case r.Delay == 0 && r.Status == 0 && !r.Abort:
+		return errors.New("one of delay, status and abort is required")
+	case r.Path != "" && !strings.HasPrefix(r.Path, "/"):
+		return errors.New("path must start with /")
+	case r.Header != nil && (r.Header.Name == "" || r.Header.Value == ""):
+		return errors.New("header needs a name and a value")
+	}
+	for i, m := range r.Methods {
+		if !isHTTPMethod(m) {
+			return fmt.Errorf("unknown method %q", m)
+		}
+		r.Methods[i] = strings.ToUpper(m)
+	}
+	return nil
+}
+
+func (r *faultRule) matches(c *gin.Context) bool {
+	if r.Service != "" {
+		route := routeFromContext(c)
+		if route == nil || route.ServiceName != r.Service {
+			return false
+		}
+	}
+	return (r.Path == "" || pathMatches(r.Path, c.Request.URL.Path)) &&
+		methodMatches(r.Methods, c.Request.Method) &&
+		(r.Header == nil || c.GetHeader(r.Header.Name) == r.Header.Value)
+}
+
+// faultInjector holds the fault rules added through the admin API on this
+// gateway instance. Rules expire on their own, so that a forgotten one does
+// not outlive the test it was added for.
+type faultInjector struct {
+	mu     sync.Mutex
+	rules  []*faultRule // in the order they were added
+	nextID int
+}
+
+func newFaultInjector() *faultInjector {
+	return &faultInjector{nextID: 1}
+}
+
+func (f *faultInjector) add(r *faultRule) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r.ID = f.nextID
+	f.nextID++
+	f.rules = append(f.rules, r)
+}
+
+// remove drops the rule with id, and reports whether there was one.
+func (f *faultInjector) remove(id int) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, r := range f.rules {
+		if r.ID == id {
+			f.rules = append(f.rules[:i], f.rules[i+1:]...)
+			return time.Now().Before(r.ExpiresAt)
+		}
+	}
+	return false
+}
+
+// clear drops every rule, and returns how many were in effect.
+func (f *faultInjector) clear() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := len(f.activeLocked())
+	f.rules = nil
+	return n
+}
+
+// list returns the rules in effect, in the order they are tried.
+func (f *faultInjector) list() []*faultRule {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.activeLocked()
+}
+
+// activeLocked drops the expired rules and returns the others.
+func (f *faultInjector) activeLocked() []*faultRule {
+	now := time.Now()
+	active := f.rules[:0]
+	for _, r := range f.rules {
+		if now.Before(r.ExpiresAt) {
+			active = append(active, r)
+		}
+	}
+	f.rules = active
+	return append([]*faultRule(nil), active...)
+}
+
+// pick returns the fault to inject into the request, if any: each matching
+// rule in turn fires for its percentage of requests, and the first that
+// fires wins.
+func (f *faultInjector) pick(c *gin.Context) *faultRule {
+	for _, r := range f.list() {
+		if r.matches(c) && rand.Float64()*100 < r.Percent {
+			return r
+		}
+	}
+	return nil
+}
+
+// faultMiddleware injects the faults of the rules added through the admin
+// API. It is only installed when fault injection is enabled.
+func faultMiddleware(faults *faultInjector) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		r := faults.pick(c)
+		if r == nil {
+			c.Next()
+			return
+		}
+		c.Set("fault_id", strconv.Itoa(r.ID))
+
+		if r.Delay > 0 && !sleepContext(c.Request.Context(), r.Delay) {
+			c.Abort()
+			return
+		}
+		switch {
+		case r.Abort:
+			abortConnection(c)
+		case r.Status != 0:
+			c.Header("X-Fault-Injected", strconv.Itoa(r.ID))
+			c.AbortWithStatusJSON(r.Status, gin.H{"error": http.StatusText(r.Status)})
+		default:
+			c.Next()
+		}
+	}
+}
+
+// sleepContext waits for d, and reports false if ctx ends first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// abortConnection closes the client's connection without a response. HTTP/2
+// connections cannot be taken over, so their requests get a 502 instead.
+func abortConnection(c *gin.Context) {
+	c.Abort()
+	conn, _, err := c.Writer.Hijack()
+	if err != nil {
+		c.Header("X-Fault-Injected", c.GetString("fault_id"))
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": "Bad gateway"})
+		return
+	}
+	conn.Close()
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/sirupsen/logrus"
+)
+
+// ----------------------------------
+// GraphQL
+// ----------------------------------
+
+// The GraphQL layer reads from the same service APIs as the REST routes,
+// through the view composer. Lookups by ID go through per-request loaders
+// that dedupe keys and fetch everything requested at one level of the query
+// together, so a list of posts costs one author lookup per distinct author.
+
+const (
+	// maxGraphQLBody caps the size of a GraphQL request.
+	maxGraphQLBody = 1 << 20
+	// defaultPageSize and maxPageSize bound paginated post lists.
+	defaultPageSize = 10
+	maxPageSize     = 50
+	// loaderConcurrency caps concurrent upstream calls of one loader batch.
+	loaderConcurrency = 8
+)
+
+// graphqlRequest is the state of one GraphQL request, available to resolvers
+// through the context.
+type graphqlRequest struct {
+	c        *gin.Context
+	views    *viewComposer
+	users    *loader
+	profiles *loader
+	posts    *loader
+	comments *loader
+}
+
+type graphqlRequestKey struct{}
+
+func graphqlRequestFrom(ctx context.Context) *graphqlRequest {
+	return ctx.Value(graphqlRequestKey{}).(*graphqlRequest)
+}
+
+// graphqlAPI serves GraphQL queries over the service APIs.
+type graphqlAPI struct {
+	views  *viewComposer
+	schema graphql.Schema
+	limits queryLimits
+	logger *logrus.Logger
+}
+
+func newGraphQLAPI(views *viewComposer, limits queryLimits, logger *logrus.Logger) (*graphqlAPI, error) {
+	schema, err := newGraphQLSchema()
+	if err != nil {
+		return nil, err
+	}
+	return &graphqlAPI{views: views, schema: schema, limits: limits, logger: logger}, nil
+}
+
+type graphqlParams struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// handler accepts queries as a JSON POST body or as GET query parameters.
+// Queries that exceed the depth or cost limits are rejected before any
+// upstream is called.
+func (g *graphqlAPI) handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var params graphqlParams
+		switch c.Request.Method {
+		case http.MethodGet:
+			params.Query = c.Query("query")
+			params.OperationName = c.Query("operationName")
+			if v := c.Query("variables"); v != "" {
+				if err := json.Unmarshal([]byte(v), &params.Variables); err != nil {
+					respondGraphQLError(c, http.StatusBadRequest, "Invalid variables")
+					return
+				}
+			}
+		default:
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxGraphQLBody)
+			if err := json.NewDecoder(c.Request.Body).Decode(&params); err != nil {
+				respondGraphQLError(c, http.StatusBadRequest, "Invalid request body")
+				return
+			}
+		}
+		if strings.TrimSpace(params.Query) == "" {
+			respondGraphQLError(c, http.StatusBadRequest, "Query is required")
+			return
+		}
+
+		if err := g.limits.check(g.schema, params.Query, params.OperationName, params.Variables); err != nil {
+			var limitErr *queryLimitError
+			if errors.As(err, &limitErr) {
+				respondGraphQLError(c, http.StatusBadRequest, limitErr.Error())
+				return
+			}
+			// Syntax errors are reported by graphql.Do in the standard format
+		}
+
+		req := g.newRequest(c)
+		result := graphql.Do(graphql.Params{
+			Schema:         g.schema,
+			RequestString:  params.Query,
+			OperationName:  params.OperationName,
+			VariableValues: params.Variables,
+			Context:        context.WithValue(c.Request.Context(), graphqlRequestKey{}, req),
+		})
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func respondGraphQLError(c *gin.Context, status int, message string) {
+	c.JSON(status, graphql.Result{Errors: []gqlerrors.FormattedError{{Message: message}}})
+}
+
+func (g *graphqlAPI) newRequest(c *gin.Context) *graphqlRequest {
+	fetchOne := func(service string, path func(key string) string) func(string) (interface{}, error) {
+		return func(key string) (interface{}, error) {
+			return sectionValue(service, g.views.fetch(c, path(key)))
+		}
+	}
+	return &graphqlRequest{
+		c:     c,
+		views: g.views,
+		users: newLoader(fetchOne("auth-service", func(id string) string {
+			return "/api/v1/auth/users/" + url.PathEscape(id)
+		})),
+		profiles: newLoader(fetchOne("user-profile-service", func(userID string) string {
+			return "/api/v1/profile/" + url.PathEscape(userID)
+		})),
+		posts: newLoader(fetchOne("post-service", func(id string) string {
+			return "/api/v1/posts/" + url.PathEscape(id)
+		})),
+		comments: newLoader(fetchOne("comment-service", func(postID string) string {
+			return "/api/v1/comments/post/" + url.PathEscape(postID)
+		})),
+	}
+}
+
+// sectionValue converts a fetched section into a resolver result. A missing
+// entity resolves to null rather than an error.
+func sectionValue(service string, s viewSection) (interface{}, error) {
+	if s.failed() {
+		if s.Error.Status == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%s: %s", service, s.Error.Message)
+	}
+	var v interface{}
+	if err := json.Unmarshal(s.Data, &v); err != nil {
+		return nil, fmt.Errorf("%s: invalid response: %v", service, err)
+	}
+	return camelKeys(v), nil
+}
+
+// camelKeys rewrites the snake_case keys of decoded JSON to the camelCase
+// field names of the schema, so the default resolvers find them.
+func camelKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			out[snakeToCamel(k)] = camelKeys(val)
+		}
+		return out
+	case []interface{}:
+		for i := range v {
+			v[i] = camelKeys(v[i])
+		}
+		return v
+	}
+	return v
+}
+
+func snakeToCamel(s string) string {
+	parts := strings.Split(s, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			r := []rune(parts[i])
+			r[0] = unicode.ToUpper(r[0])
+			parts[i] = string(r)
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// ----------------------------------
+// Loaders
+// ----------------------------------
+
+// loader dedupes lookups by key within a request and batches them. load
+// returns a thunk; graphql-go calls thunks level by level, and the first one
+// called fetches every key queued so far, concurrently.
+type loader struct {
+	fetch func(key string) (interface{}, error)
+
+	mu      sync.Mutex
+	entries map[string]*loaderEntry
+	pending []*loaderEntry
+}
+
+type loaderEntry struct {
+	key   string
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+func newLoader(fetch func(key string) (interface{}, error)) *loader {
+	return &loader{fetch: fetch, entries: make(map[string]*loaderEntry)}
+}
+
+func (l *loader) load(key string) func() (interface{}, error) {
+	l.mu.Lock()
+	e, ok := l.entries[key]
+	if !ok {
+		e = &loaderEntry{key: key, done: make(chan struct{})}
+		l.entries[key] = e
+		l.pending = append(l.pending, e)
+	}
+	l.mu.Unlock()
+
+	return func() (interface{}, error) {
+		l.dispatch()
+		<-e.done
+		return e.value, e.err
+	}
+}
+
+// dispatch fetches all queued keys.
+func (l *loader) dispatch() {
+	l.mu.Lock()
+	batch := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, loaderConcurrency)
+	for _, e := range batch {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			e.value, e.err = l.fetch(e.key)
+			close(e.done)
+		}()
+	}
+	wg.Wait()
+}
+
+// ----------------------------------
+// Schema
+// ----------------------------------
+
+func newGraphQLSchema() (graphql.Schema, error) {
+	var userType, profileType, postType, commentType, postPageType *graphql.Object
+
+	pageArgs := graphql.FieldConfigArgument{
+		"page":     &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 1},
+		"pageSize": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultPageSize},
+	}
+	withPageArgs := func(args graphql.FieldConfigArgument) graphql.FieldConfigArgument {
+		for k, v := range pageArgs {
+			args[k] = v
+		}
+		return args
+	}
+
+	userType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "User",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id":        &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+				"email":     &graphql.Field{Type: graphql.String},
+				"createdAt": &graphql.Field{Type: graphql.String},
+				"updatedAt": &graphql.Field{Type: graphql.String},
+				"profile": &graphql.Field{
+					Type: profileType,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return loadProfile(p, stringField(p.Source, "id"))
+					},
+				},
+				"posts": &graphql.Field{
+					Type: postPageType,
+					Args: pageArgs,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return fetchPostPage(p, "/api/v1/posts/user/"+url.PathEscape(stringField(p.Source, "id")))
+					},
+				},
+			}
+		}),
+	})
+
+	profileType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Profile",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id":          &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+				"userId":      &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+				"bio":         &graphql.Field{Type: graphql.String},
+				"avatarUrl":   &graphql.Field{Type: graphql.String},
+				"twitterUrl":  &graphql.Field{Type: graphql.String},
+				"linkedinUrl": &graphql.Field{Type: graphql.String},
+				"githubUrl":   &graphql.Field{Type: graphql.String},
+				"createdAt":   &graphql.Field{Type: graphql.String},
+				"updatedAt":   &graphql.Field{Type: graphql.String},
+				"user": &graphql.Field{
+					Type: userType,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return loadUser(p, stringField(p.Source, "userId"))
+					},
+				},
+			}
+		}),
+	})
+
+	postType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Post",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id":        &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+				"userId":    &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+				"title":     &graphql.Field{Type: graphql.String},
+				"content":   &graphql.Field{Type: graphql.String},
+				"tags":      &graphql.Field{Type: graphql.NewList(graphql.String)},
+				"createdAt": &graphql.Field{Type: graphql.String},
+				"updatedAt": &graphql.Field{Type: graphql.String},
+				"author": &graphql.Field{
+					Type: userType,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return loadUser(p, stringField(p.Source, "userId"))
+					},
+				},
+				"comments": &graphql.Field{
+					Type: graphql.NewList(commentType),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return graphqlRequestFrom(p.Context).comments.load(stringField(p.Source, "id")), nil
+					},
+				},
+			}
+		}),
+	})
+
+	commentType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Comment",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id":        &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+				"postId":    &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+				"userId":    &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+				"content":   &graphql.Field{Type: graphql.String},
+				"createdAt": &graphql.Field{Type: graphql.String},
+				"author": &graphql.Field{
+					Type: userType,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return loadUser(p, stringField(p.Source, "userId"))
+					},
+				},
+				"post": &graphql.Field{
+					Type: postType,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return graphqlRequestFrom(p.Context).posts.load(stringField(p.Source, "postId")), nil
+					},
+				},
+			}
+		}),
+	})
+
+	postPageType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "PostPage",
+		Fields: graphql.Fields{
+			"posts":      &graphql.Field{Type: graphql.NewList(postType)},
+			"totalCount": &graphql.Field{Type: graphql.Int},
+			"page":       &graphql.Field{Type: graphql.Int},
+			"pageSize":   &graphql.Field{Type: graphql.Int},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"post": &graphql.Field{
+				Type: postType,
+				Args: graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return graphqlRequestFrom(p.Context).posts.load(p.Args["id"].(string)), nil
+				},
+			},
+			"posts": &graphql.Field{
+				Type: postPageType,
+				Args: withPageArgs(graphql.FieldConfigArgument{}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return fetchPostPage(p, "/api/v1/posts")
+				},
+			},
+			"postsByUser": &graphql.Field{
+				Type: postPageType,
+				Args: withPageArgs(graphql.FieldConfigArgument{
+					"userId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return fetchPostPage(p, "/api/v1/posts/user/"+url.PathEscape(p.Args["userId"].(string)))
+				},
+			},
+			"postsByTag": &graphql.Field{
+				Type: postPageType,
+				Args: withPageArgs(graphql.FieldConfigArgument{
+					"tag": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return fetchPostPage(p, "/api/v1/posts/tag/"+url.PathEscape(p.Args["tag"].(string)))
+				},
+			},
+			"comments": &graphql.Field{
+				Type: graphql.NewList(commentType),
+				Args: graphql.FieldConfigArgument{"postId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return graphqlRequestFrom(p.Context).comments.load(p.Args["postId"].(string)), nil
+				},
+			},
+			"user": &graphql.Field{
+				Type: userType,
+				Args: graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return loadUser(p, p.Args["id"].(string))
+				},
+			},
+			"profile": &graphql.Field{
+				Type: profileType,
+				Args: graphql.FieldConfigArgument{"userId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return loadProfile(p, p.Args["userId"].(string))
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query})
+}
+
+// loadUser looks up a user. Like the REST route to the auth service, user
+// lookups require an authenticated caller.
+func loadUser(p graphql.ResolveParams, id string) (interface{}, error) {
+	req := graphqlRequestFrom(p.Context)
+	if _, ok := req.c.Get("user_id"); !ok {
+		return nil, errors.New("authentication required")
+	}
+	if id == "" {
+		return nil, nil
+	}
+	return req.users.load(id), nil
+}
+
+func loadProfile(p graphql.ResolveParams, userID string) (interface{}, error) {
+	if userID == "" {
+		return nil, nil
+	}
+	return graphqlRequestFrom(p.Context).profiles.load(userID), nil
+}
+
+// fetchPostPage fetches one page of a paginated post listing.
+func fetchPostPage(p graphql.ResolveParams, path string) (interface{}, error) {
+	page, _ := p.Args["page"].(int)
+	pageSize, _ := p.Args["pageSize"].(int)
+	if page < 1 {
+		page = 1
+	}
+	pageSize = clampPageSize(pageSize)
+
+	req := graphqlRequestFrom(p.Context)
+	q := url.Values{"page": {fmt.Sprint(page)}, "page_size": {fmt.Sprint(pageSize)}}
+	return sectionValue("post-service", req.views.fetch(req.c, path+"?"+q.Encode()))
+}
+
+func clampPageSize(n int) int {
+	if n < 1 {
+		return defaultPageSize
+	}
+	return min(n, maxPageSize)
+}
+
+func stringField(source interface{}, key string) string {
+	m, _ := source.(map[string]interface{})
+	s, _ := m[key].(string)
+	return s
+}
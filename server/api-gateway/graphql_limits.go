@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+// ----------------------------------
+// GraphQL query limits
+// ----------------------------------
+
+// queryLimits bounds the work a single GraphQL query can cause.
+//
+// Depth counts nested selections of object fields. Cost estimates upstream
+// calls: every object field costs 1, multiplied by the expected length of
+// each list it appears in. A paginated field's list is as long as its
+// pageSize argument; other lists are assumed to have defaultListCost items.
+// Introspection fields are exempt from both.
+type queryLimits struct {
+	MaxDepth int
+	MaxCost  int
+}
+
+const defaultListCost = 10
+
+// queryLimitError reports a query that exceeds a limit.
+type queryLimitError struct {
+	msg string
+}
+
+func (e *queryLimitError) Error() string {
+	return e.msg
+}
+
+// check parses query and verifies the selected operation against the
+// limits. Parse errors are returned as plain errors so that the executor can
+// report them.
+func (l queryLimits) check(schema graphql.Schema, query, operationName string, variables map[string]interface{}) error {
+	doc, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(query)})})
+	if err != nil {
+		return err
+	}
+
+	fragments := make(map[string]*ast.FragmentDefinition)
+	var op *ast.OperationDefinition
+	for _, def := range doc.Definitions {
+		switch def := def.(type) {
+		case *ast.FragmentDefinition:
+			fragments[def.Name.Value] = def
+		case *ast.OperationDefinition:
+			if operationName == "" || (def.Name != nil && def.Name.Value == operationName) {
+				op = def
+			}
+		}
+	}
+	if op == nil {
+		return fmt.Errorf("unknown operation %q", operationName)
+	}
+
+	root := schema.QueryType()
+	if op.Operation == ast.OperationTypeMutation {
+		root = schema.MutationType()
+	}
+	a := &queryAnalysis{schema: schema, fragments: fragments, variables: variables}
+	depth, cost := a.selectionSet(op.SelectionSet, root, 0, nil)
+	if l.MaxDepth > 0 && depth > l.MaxDepth {
+		return &queryLimitError{fmt.Sprintf("query depth %d exceeds the limit of %d", depth, l.MaxDepth)}
+	}
+	if l.MaxCost > 0 && cost > l.MaxCost {
+		return &queryLimitError{fmt.Sprintf("query cost %d exceeds the limit of %d", cost, l.MaxCost)}
+	}
+	return nil
+}
+
+type queryAnalysis struct {
+	schema    graphql.Schema
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+}
+
+// selectionSet returns the depth and cost of a selection set on parent.
+// listHint is the list length announced by a paginated ancestor, 0 if none.
+// visiting guards against fragment cycles, which validation rejects later.
+func (a *queryAnalysis) selectionSet(set *ast.SelectionSet, parent *graphql.Object, listHint int, visiting map[string]bool) (depth, cost int) {
+	if set == nil || parent == nil {
+		return 0, 0
+	}
+	for _, sel := range set.Selections {
+		var d, c int
+		switch sel := sel.(type) {
+		case *ast.Field:
+			d, c = a.field(sel, parent, listHint, visiting)
+		case *ast.InlineFragment:
+			typ := parent
+			if sel.TypeCondition != nil {
+				if t, ok := a.schema.Type(sel.TypeCondition.Name.Value).(*graphql.Object); ok {
+					typ = t
+				}
+			}
+			d, c = a.selectionSet(sel.SelectionSet, typ, listHint, visiting)
+		case *ast.FragmentSpread:
+			name := sel.Name.Value
+			frag, ok := a.fragments[name]
+			if !ok || visiting[name] {
+				continue
+			}
+			if visiting == nil {
+				visiting = make(map[string]bool)
+			}
+			visiting[name] = true
+			typ, _ := a.schema.Type(frag.TypeCondition.Name.Value).(*graphql.Object)
+			d, c = a.selectionSet(frag.SelectionSet, typ, listHint, visiting)
+			delete(visiting, name)
+		}
+		depth = max(depth, d)
+		cost += c
+	}
+	return depth, cost
+}
+
+func (a *queryAnalysis) field(f *ast.Field, parent *graphql.Object, listHint int, visiting map[string]bool) (depth, cost int) {
+	name := f.Name.Value
+	if strings.HasPrefix(name, "__") {
+		return 0, 0
+	}
+	def, ok := parent.Fields()[name]
+	if !ok {
+		return 0, 0
+	}
+
+	typ := def.Type
+	isList := false
+	for {
+		switch t := typ.(type) {
+		case *graphql.NonNull:
+			typ = t.OfType
+			continue
+		case *graphql.List:
+			isList = true
+			typ = t.OfType
+			continue
+		}
+		break
+	}
+	obj, ok := typ.(*graphql.Object)
+	if !ok {
+		// Scalars are part of the object that holds them
+		return 0, 0
+	}
+
+	multiplier := 1
+	if isList {
+		multiplier = defaultListCost
+		if listHint > 0 {
+			multiplier = listHint
+		}
+		listHint = 0
+	}
+	if size, ok := a.pageSize(f); ok {
+		listHint = size
+	}
+
+	childDepth, childCost := a.selectionSet(f.SelectionSet, obj, listHint, visiting)
+	return childDepth + 1, multiplier * (1 + childCost)
+}
+
+// pageSize returns the effective pageSize argument of a paginated field.
+func (a *queryAnalysis) pageSize(f *ast.Field) (int, bool) {
+	for _, arg := range f.Arguments {
+		if arg.Name.Value != "pageSize" {
+			continue
+		}
+		switch v := arg.Value.(type) {
+		case *ast.IntValue:
+			n, _ := strconv.Atoi(v.Value)
+			return clampPageSize(n), true
+		case *ast.Variable:
+			if n, ok := a.variables[v.Name.Value].(float64); ok {
+				return clampPageSize(int(n)), true
+			}
+		}
+		return defaultPageSize, true
+	}
+	return defaultPageSize, false
+}
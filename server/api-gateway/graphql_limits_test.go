@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestQueryLimits(t *testing.T) {
+	schema, err := newGraphQLSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]interface{}
+		depth     int
+		cost      int
+	}{
+		{name: "single object", query: `{ post(id: "1") { id title } }`, depth: 1, cost: 1},
+		{name: "nested object", query: `{ post(id: "1") { author { id profile { bio } } } }`, depth: 3, cost: 3},
+		{name: "scalar lists are free", query: `{ post(id: "1") { tags } }`, depth: 1, cost: 1},
+		{name: "list of the default length", query: `{ comments(postId: "1") { id } }`, depth: 1, cost: defaultListCost},
+		{name: "nested lists multiply", query: `{ comments(postId: "1") { post { comments { id } } } }`,
+			depth: 3, cost: defaultListCost * (1 + 1*(1+defaultListCost))},
+		{name: "page of the default size", query: `{ posts { posts { id } } }`, depth: 2, cost: 1 + defaultPageSize},
+		{name: "page size argument", query: `{ posts(pageSize: 3) { posts { id author { id } } } }`,
+			depth: 3, cost: 1 + 3*(1+1)},
+		{name: "page size is clamped", query: `{ posts(pageSize: 1000) { posts { id } } }`, depth: 2, cost: 1 + maxPageSize},
+		{name: "page size variable", query: `query Q($n: Int) { posts(pageSize: $n) { posts { id } } }`,
+			variables: map[string]interface{}{"n": float64(4)}, depth: 2, cost: 1 + 4},
+		{name: "missing page size variable", query: `query Q($n: Int) { posts(pageSize: $n) { posts { id } } }`,
+			depth: 2, cost: 1 + defaultPageSize},
+		{name: "page size applies to its own list only", query: `{ postsByUser(userId: "u", pageSize: 2) { posts { comments { id } } } }`,
+			depth: 3, cost: 1 + 2*(1+defaultListCost)},
+		{name: "aliases add up", query: `{ a: post(id: "1") { id } b: post(id: "2") { author { id } } }`, depth: 2, cost: 1 + 2},
+		{name: "inline fragment", query: `{ post(id: "1") { ... on Post { author { id } } } }`, depth: 2, cost: 2},
+		{name: "fragment spread", query: `{ post(id: "1") { ...P } } fragment P on Post { author { id } }`, depth: 2, cost: 2},
+		{name: "fragment cycle", query: `{ post(id: "1") { ...A } }
+			fragment A on Post { author { ...B } }
+			fragment B on User { posts { posts { ...A } } }`,
+			depth: 4, cost: 1 + 1*(1+1*(1+defaultPageSize))},
+		{name: "self-referencing fragment", query: `{ post(id: "1") { ...A } } fragment A on Post { id ...A }`, depth: 1, cost: 1},
+		{name: "introspection is exempt", query: `{ __schema { types { name fields { name } } } }`, depth: 0, cost: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at := queryLimits{MaxDepth: max(tt.depth, 1), MaxCost: max(tt.cost, 1)}
+			if err := at.check(schema, tt.query, "", tt.variables); err != nil {
+				t.Fatalf("at the limits: %v", err)
+			}
+			if tt.depth > 0 {
+				over := queryLimits{MaxDepth: tt.depth - 1}
+				want := fmt.Sprintf("query depth %d exceeds the limit of %d", tt.depth, tt.depth-1)
+				if tt.depth == 1 {
+					// A limit of zero is no limit
+					want = ""
+				}
+				checkLimitError(t, over.check(schema, tt.query, "", tt.variables), want)
+			}
+			if tt.cost > 1 {
+				over := queryLimits{MaxCost: tt.cost - 1}
+				want := fmt.Sprintf("query cost %d exceeds the limit of %d", tt.cost, tt.cost-1)
+				checkLimitError(t, over.check(schema, tt.query, "", tt.variables), want)
+			}
+		})
+	}
+}
+
+func checkLimitError(t *testing.T, err error, want string) {
+	t.Helper()
+	if want == "" {
+		if err != nil {
+			t.Errorf("got %v, want no error", err)
+		}
+		return
+	}
+	var limitErr *queryLimitError
+	if !errors.As(err, &limitErr) || limitErr.Error() != want {
+		t.Errorf("got %v, want %q", err, want)
+	}
+}
+
+func TestQueryLimitsOperations(t *testing.T) {
+	schema, err := newGraphQLSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := queryLimits{MaxDepth: 1, MaxCost: 1}
+	const query = `query Small { post(id: "1") { id } } query Big { post(id: "1") { author { id } } }`
+
+	if err := limits.check(schema, query, "Small", nil); err != nil {
+		t.Errorf("Small: %v", err)
+	}
+	checkLimitError(t, limits.check(schema, query, "Big", nil), "query depth 2 exceeds the limit of 1")
+
+	var limitErr *queryLimitError
+	if err := limits.check(schema, query, "Missing", nil); err == nil || errors.As(err, &limitErr) {
+		t.Errorf("unknown operation: got %v, want a plain error", err)
+	}
+	if err := limits.check(schema, `{ post(id: "1") {`, "", nil); err == nil || errors.As(err, &limitErr) {
+		t.Errorf("syntax error: got %v, want a plain error", err)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+)
+
+// fetchLog records the keys a loader fetches, and checks that every key of a
+// level was queued before the first of them was fetched.
+type fetchLog struct {
+	t      *testing.T
+	loader *loader
+	value  func(key string) interface{}
+	levels map[string][]string // key -> the keys that must be queued with it
+
+	mu      sync.Mutex
+	fetched map[string]int
+}
+
+func newFetchLog(t *testing.T, levels [][]string, value func(key string) interface{}) *fetchLog {
+	f := &fetchLog{t: t, value: value, levels: make(map[string][]string), fetched: make(map[string]int)}
+	for _, level := range levels {
+		for _, key := range level {
+			f.levels[key] = level
+		}
+	}
+	f.loader = newLoader(f.fetch)
+	return f
+}
+
+func (f *fetchLog) fetch(key string) (interface{}, error) {
+	f.loader.mu.Lock()
+	for _, other := range f.levels[key] {
+		if _, queued := f.loader.entries[other]; !queued {
+			f.t.Errorf("%s fetched before %s of the same level was queued", key, other)
+		}
+	}
+	f.loader.mu.Unlock()
+
+	f.mu.Lock()
+	f.fetched[key]++
+	f.mu.Unlock()
+	return f.value(key), nil
+}
+
+func (f *fetchLog) keys() map[string]int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fetched
+}
+
+func TestLoader(t *testing.T) {
+	f := newFetchLog(t, [][]string{{"a", "b"}, {"c"}}, func(key string) interface{} { return "value of " + key })
+	l := f.loader
+
+	a1, b, a2 := l.load("a"), l.load("b"), l.load("a")
+	if v, err := a1(); v != "value of a" || err != nil {
+		t.Errorf("a: got %v, %v", v, err)
+	}
+	if got := f.keys(); got["a"] != 1 || got["b"] != 1 {
+		t.Errorf("first thunk fetched %v, want a and b once", got)
+	}
+	if v, _ := b(); v != "value of b" {
+		t.Errorf("b: got %v", v)
+	}
+	if v, _ := a2(); v != "value of a" {
+		t.Errorf("a again: got %v", v)
+	}
+
+	// Keys already fetched are not fetched again; new ones form a new batch
+	c, a3 := l.load("c"), l.load("a")
+	c()
+	a3()
+	if got := f.keys(); len(got) != 3 || got["a"] != 1 || got["b"] != 1 || got["c"] != 1 {
+		t.Errorf("fetched %v, want a, b and c once each", got)
+	}
+}
+
+func TestLoaderBatchesPerLevel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	schema, err := newGraphQLSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each post has comments on posts x and y, which have comments of their own
+	comments := newFetchLog(t, [][]string{{"p1", "p2"}, {"x", "y"}}, func(postID string) interface{} {
+		return []interface{}{
+			map[string]interface{}{"id": postID + "-1", "postId": "x"},
+			map[string]interface{}{"id": postID + "-2", "postId": "y"},
+		}
+	})
+	posts := newFetchLog(t, [][]string{{"x", "y"}}, func(id string) interface{} {
+		return map[string]interface{}{"id": id}
+	})
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	req := &graphqlRequest{c: c, comments: comments.loader, posts: posts.loader}
+	result := graphql.Do(graphql.Params{
+		Schema: schema,
+		RequestString: `{
+			a: comments(postId: "p1") { id post { id comments { id } } }
+			b: comments(postId: "p2") { id post { id } }
+			c: comments(postId: "p1") { id }
+		}`,
+		Context: context.WithValue(context.Background(), graphqlRequestKey{}, req),
+	})
+	if result.HasErrors() {
+		t.Fatalf("errors: %v", result.Errors)
+	}
+
+	for name, f := range map[string]*fetchLog{"comments": comments, "posts": posts} {
+		for key, n := range f.keys() {
+			if n != 1 {
+				t.Errorf("%s: %s fetched %d times, want once", name, key, n)
+			}
+		}
+	}
+	if got := comments.keys(); len(got) != 4 {
+		t.Errorf("comments fetched %v, want p1, p2, x and y", got)
+	}
+	if got := posts.keys(); len(got) != 2 {
+		t.Errorf("posts fetched %v, want x and y", got)
+	}
+}
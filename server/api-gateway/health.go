@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ----------------------------------
+// Active health checks
+// ----------------------------------
+
+type healthCheckConfig struct {
+	// Interval is the time between two probes of an instance.
+	Interval time.Duration
+	// Timeout bounds a single probe.
+	Timeout time.Duration
+	// UnhealthyThreshold is the number of consecutive failed probes that
+	// takes an instance out of rotation.
+	UnhealthyThreshold int
+	// HealthyThreshold is the number of consecutive successful probes that
+	// puts it back.
+	HealthyThreshold int
+}
+
+// instanceHealth is the result of the probes of one instance. Instances
+// start out healthy so that a new instance takes traffic before its first
+// probe; its circuit breaker still protects against one that is down.
+type instanceHealth struct {
+	mu                   sync.Mutex
+	unhealthy            bool
+	consecutiveFailures  int
+	consecutiveSuccesses int
+	failures             int64
+	latency              time.Duration
+	lastChecked          time.Time
+	lastSuccess          time.Time
+	lastFailure          time.Time
+	lastError            string
+}
+
+// healthSnapshot is the reported state of an instance's health checks.
+type healthSnapshot struct {
+	Address             string     `json:"address"`
+	Healthy             bool       `json:"healthy"`
+	LatencyMs           float64    `json:"latency_ms"`
+	LastChecked         *time.Time `json:"last_checked,omitempty"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	LastFailure         *time.Time `json:"last_failure,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	Failures            int64      `json:"failures"`
+}
+
+// Healthy reports whether the instance is in rotation.
+func (h *instanceHealth) Healthy() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !h.unhealthy
+}
+
+// record applies the result of a probe, and reports whether the instance
+// changed between healthy and unhealthy.
+func (h *instanceHealth) record(cfg healthCheckConfig, latency time.Duration, err error) (changed bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	h.latency = latency
+	h.lastChecked = now
+	if err != nil {
+		h.failures++
+		h.consecutiveFailures++
+		h.consecutiveSuccesses = 0
+		h.lastFailure = now
+		h.lastError = err.Error()
+		if !h.unhealthy && h.consecutiveFailures >= cfg.UnhealthyThreshold {
+			h.unhealthy = true
+			return true
+		}
+		return false
+	}
+
+	h.consecutiveFailures = 0
+	h.consecutiveSuccesses++
+	h.lastSuccess = now
+	if h.unhealthy && h.consecutiveSuccesses >= cfg.HealthyThreshold {
+		h.unhealthy = false
+		return true
+	}
+	return false
+}
+
+func (h *instanceHealth) snapshot(address string) healthSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := healthSnapshot{
+		Address:             address,
+		Healthy:             !h.unhealthy,
+		LatencyMs:           float64(h.latency.Microseconds()) / 1000,
+		LastError:           h.lastError,
+		ConsecutiveFailures: h.consecutiveFailures,
+		Failures:            h.failures,
+	}
+	s.LastChecked = timeOrNil(h.lastChecked)
+	s.LastSuccess = timeOrNil(h.lastSuccess)
+	s.LastFailure = timeOrNil(h.lastFailure)
+	return s
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// healthChecker probes the health endpoint of every discovered instance.
+// The endpoint of a service is the health path of the routes that forward to
+// it.
+type healthChecker struct {
+	cfg    healthCheckConfig
+	disc   *Discovery
+	routes *routeStore
+	client *http.Client
+	logger *logrus.Logger
+}
+
+func newHealthChecker(cfg healthCheckConfig, disc *Discovery, routes *routeStore, logger *logrus.Logger) *healthChecker {
+	if cfg.UnhealthyThreshold < 1 {
+		cfg.UnhealthyThreshold = 1
+	}
+	if cfg.HealthyThreshold < 1 {
+		cfg.HealthyThreshold = 1
+	}
+	return &healthChecker{
+		cfg:    cfg,
+		disc:   disc,
+		routes: routes,
+		client: &http.Client{Timeout: cfg.Timeout},
+		logger: logger,
+	}
+}
+
+// start probes every instance each Interval until the process exits.
+func (hc *healthChecker) start() {
+	go func() {
+		ticker := time.NewTicker(hc.cfg.Interval)
+		for {
+			hc.probeAll()
+			<-ticker.C
+		}
+	}()
+}
+
+func (hc *healthChecker) probeAll() {
+	table := hc.routes.Load()
+	var wg sync.WaitGroup
+	for _, service := range table.services() {
+		path := table.healthPath(service)
+		for _, inst := range hc.disc.Instances(service) {
+			if inst.Mode() == instanceDisabled {
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				hc.probe(inst, path)
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+func (hc *healthChecker) probe(inst *upstreamInstance, path string) {
+	ctx, cancel := context.WithTimeout(context.Background(), hc.cfg.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := hc.check(ctx, inst.Address+path)
+	latency := time.Since(start)
+
+	if !inst.health.record(hc.cfg, latency, err) {
+		return
+	}
+	entry := hc.logger.WithFields(logrus.Fields{"service": inst.Service, "instance": inst.ID, "address": inst.Address})
+	if err != nil {
+		entry.WithError(err).Warn("Instance failed its health checks; taking it out of rotation")
+	} else {
+		entry.Info("Instance passed its health checks; putting it back in rotation")
+	}
+}
+
+func (hc *healthChecker) check(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := hc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("health check returned %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -2,155 +2,27 @@ package main
 
 import (
     "context"
-    "encoding/json"
+    "errors"
     "fmt"
     "log"
     "net/http"
-    "net/http/httputil"
-    "net/url"
     "os"
+    "os/signal"
     "strings"
     "sync"
+    "sync/atomic"
+    "syscall"
     "time"
 
     "github.com/gin-gonic/gin"
     "github.com/golang-jwt/jwt/v5"
+    "github.com/omsurase/blogger_microservices/server/pkg/tracing"
     "github.com/sirupsen/logrus"
-    "golang.org/x/time/rate"
     "github.com/redis/go-redis/v9"
+    "go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+    "go.opentelemetry.io/otel/trace"
 )
 
-// ----------------------------------
-// Service discovery
-// ----------------------------------
-
-type serviceInfo struct {
-    Name    string `json:"name"`
-    Address string `json:"address"`
-}
-
-type registryResponse struct {
-    Status int            `json:"status"`
-    Data   []serviceInfo  `json:"data"`
-}
-
-type Discovery struct {
-    registryURL     string
-    refreshInterval time.Duration
-
-    mu       sync.RWMutex
-    services map[string]string // name -> address
-    logger   *logrus.Logger
-}
-
-func NewDiscovery(registryURL string, refreshInterval time.Duration, logger *logrus.Logger) *Discovery {
-    d := &Discovery{
-        registryURL:     strings.TrimSuffix(registryURL, "/"),
-        refreshInterval: refreshInterval,
-        services:        make(map[string]string),
-        logger:          logger,
-    }
-    go d.refreshLoop()
-    return d
-}
-
-func (d *Discovery) refreshLoop() {
-    ticker := time.NewTicker(d.refreshInterval)
-    for {
-        d.refresh()
-        <-ticker.C
-    }
-}
-
-func (d *Discovery) refresh() {
-    resp, err := http.Get(fmt.Sprintf("%s/services", d.registryURL))
-    if err != nil {
-        d.logger.WithError(err).Warn("Failed to fetch services from registry")
-        return
-    }
-    defer resp.Body.Close()
-
-    if resp.StatusCode != http.StatusOK {
-        d.logger.Warnf("Unexpected status from registry: %d", resp.StatusCode)
-        return
-    }
-
-    var rr registryResponse
-    if err := json.NewDecoder(resp.Body).Decode(&rr); err != nil {
-        d.logger.WithError(err).Warn("Failed to decode registry response")
-        return
-    }
-
-    m := make(map[string]string)
-    for _, svc := range rr.Data {
-        m[svc.Name] = strings.TrimSuffix(svc.Address, "/")
-    }
-
-    d.mu.Lock()
-    d.services = m
-    d.mu.Unlock()
-
-    d.logger.Debugf("Service registry refreshed: %+v", m)
-}
-
-func (d *Discovery) GetAddress(name string) string {
-    d.mu.RLock()
-    addr := d.services[name]
-    d.mu.RUnlock()
-    return addr
-}
-
-func (d *Discovery) GetAllServices() map[string]string {
-    d.mu.RLock()
-    defer d.mu.RUnlock()
-    services := make(map[string]string)
-    for k, v := range d.services {
-        services[k] = v
-    }
-    return services
-}
-
-// ----------------------------------
-// Rate limiter per IP
-// ----------------------------------
-
-type ipRateLimiter struct {
-    visitors map[string]*rate.Limiter
-    mu       sync.Mutex
-    r        rate.Limit
-    b        int
-}
-
-func newIPRateLimiter(r rate.Limit, b int) *ipRateLimiter {
-    return &ipRateLimiter{
-        visitors: make(map[string]*rate.Limiter),
-        r:        r,
-        b:        b,
-    }
-}
-
-func (i *ipRateLimiter) getLimiter(ip string) *rate.Limiter {
-    i.mu.Lock()
-    defer i.mu.Unlock()
-    limiter, exists := i.visitors[ip]
-    if !exists {
-        limiter = rate.NewLimiter(i.r, i.b)
-        i.visitors[ip] = limiter
-    }
-    return limiter
-}
-
-// ----------------------------------
-// Route mapping
-// ----------------------------------
-
-type routeMapping struct {
-    Prefix        string
-    ServiceName   string
-    RewritePrefix string
-    AuthRequired  bool
-}
-
 // ----------------------------------
 // JWT utilities
 // ----------------------------------
@@ -179,7 +51,7 @@ func parseJWT(tokenString string, secret []byte) (jwt.MapClaims, error) {
 type HealthStatus struct {
     Status    string            `json:"status"`
     Timestamp time.Time         `json:"timestamp"`
-    Services  map[string]string `json:"services"`
+    Services  map[string][]string `json:"services"`
     Gateway   GatewayHealth     `json:"gateway"`
 }
 
@@ -191,94 +63,55 @@ type GatewayHealth struct {
 }
 
 // ----------------------------------
-// Gateway handler factory
+// Global JWT middleware (enforces the auth level of each route)
 // ----------------------------------
 
-func makeProxyHandler(mapping routeMapping, disc *Discovery, logger *logrus.Logger) gin.HandlerFunc {
+// jwtAuthMiddleware identifies callers by a Bearer JWT or by an API key sent
+// as "Authorization: ApiKey <key>". Callers using a key act with its scopes
+// only; the key itself is not forwarded.
+func jwtAuthMiddleware(secret []byte, redisClient *redis.Client, local localAuthPolicies) gin.HandlerFunc {
     return func(c *gin.Context) {
-        // No JWT handling here – performed globally.
-
-        // Lookup service
-        targetAddr := disc.GetAddress(mapping.ServiceName)
-        if targetAddr == "" {
-            logger.Errorf("service %s not found in registry", mapping.ServiceName)
-            c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Service unavailable"})
-            return
-        }
+        // Identity headers only ever come from the gateway
+        stripIdentity(c.Request)
 
-        // Build reverse proxy
-        targetURL, err := url.Parse(targetAddr)
-        if err != nil {
-            logger.WithError(err).Error("invalid service address")
-            c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid service address"})
+        level := authLevelFor(c, local)
+        if level == authAnonymous {
+            c.Next()
             return
         }
 
-        // Build director function
-        director := func(req *http.Request) {
-            req.URL.Scheme = targetURL.Scheme
-            req.URL.Host = targetURL.Host
-            // rewrite path
-            incomingPath := req.URL.Path
-            trimmed := strings.TrimPrefix(incomingPath, mapping.Prefix)
-            newPath := mapping.RewritePrefix + trimmed
-            if !strings.HasPrefix(newPath, "/") {
-                newPath = "/" + newPath
-            }
-            req.URL.Path = newPath
-            req.URL.RawPath = newPath
-            // retain query
-            // headers already present
-            req.Host = targetURL.Host
-        }
-
-        proxy := &httputil.ReverseProxy{
-            Director: director,
-            Transport: &http.Transport{
-                Proxy:               http.ProxyFromEnvironment,
-                ResponseHeaderTimeout: 10 * time.Second,
-            },
-            ErrorHandler: func(rw http.ResponseWriter, r *http.Request, e error) {
-                logger.WithError(e).Error("proxy error")
-                rw.WriteHeader(http.StatusBadGateway)
-            },
-        }
-
-        // Apply per-request timeout
-        ctx, cancel := context.WithTimeout(c.Request.Context(), 15*time.Second)
-        defer cancel()
-        c.Request = c.Request.WithContext(ctx)
-
-        proxy.ServeHTTP(c.Writer, c.Request)
-    }
-}
-
-// ----------------------------------
-// Global JWT middleware (validate all requests except login/signup)
-// ----------------------------------
-
-func jwtAuthMiddleware(secret []byte, redisClient *redis.Client) gin.HandlerFunc {
-    return func(c *gin.Context) {
-        path := c.Request.URL.Path
-
-        // Skip authentication for login, signup, and health endpoints
-        if strings.HasPrefix(path, "/api/v1/auth/login") || 
-           strings.HasPrefix(path, "/api/v1/auth/signup") || 
-           strings.HasPrefix(path, "/api/v1/auth/register") ||
-           strings.HasPrefix(path, "/health") ||
-           strings.HasSuffix(path, "/health") ||
-           strings.HasPrefix(path, "/service-registry/health") {
+        // Optional auth lets callers without a token through unidentified;
+        // a token that is present must still be valid
+        authHeader := c.GetHeader("Authorization")
+        if authHeader == "" && level == authOptional {
             c.Next()
             return
         }
-
-        authHeader := c.GetHeader("Authorization")
         if authHeader == "" {
             c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
             c.Abort()
             return
         }
 
+        if key, ok := strings.CutPrefix(authHeader, apiKeyScheme); ok {
+            record, err := resolveAPIKey(c.Request.Context(), redisClient, key)
+            if errors.Is(err, errInvalidAPIKey) {
+                c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+                c.Abort()
+                return
+            } else if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+                c.Abort()
+                return
+            }
+            c.Request.Header.Del("Authorization")
+            c.Set("user_id", record.UserID)
+            c.Set("user_scopes", record.Scopes)
+            c.Set("api_key_id", record.ID)
+            c.Next()
+            return
+        }
+
         tokenString := strings.TrimPrefix(authHeader, "Bearer ")
         if tokenString == authHeader {
             c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token format"})
@@ -310,13 +143,12 @@ func jwtAuthMiddleware(secret []byte, redisClient *redis.Client) gin.HandlerFunc
             }
         }
 
-        // Propagate user info to downstream services via headers
+        // The proxy forwards the caller's identity as a signed assertion
         if uid, ok := claims["user_id"]; ok {
-            c.Request.Header.Set("X-User-ID", fmt.Sprintf("%v", uid))
             c.Set("user_id", uid)
         }
         if email, ok := claims["email"]; ok {
-            c.Request.Header.Set("X-User-Email", fmt.Sprintf("%v", email))
+            c.Set("user_email", email)
         }
 
         c.Next()
@@ -351,6 +183,12 @@ func main() {
         logger.Warn("JWT_SECRET_KEY not set; JWT validation will fail")
     }
 
+    identitySecret := []byte(os.Getenv("INTERNAL_IDENTITY_SECRET"))
+    if len(identitySecret) == 0 {
+        log.Fatal("INTERNAL_IDENTITY_SECRET env required")
+    }
+    identity := newIdentitySigner(identitySecret, envDuration(logger, "INTERNAL_IDENTITY_TTL", defaultIdentityTTL))
+
     environment := os.Getenv("ENVIRONMENT")
     if environment == "" {
         environment = "development"
@@ -361,43 +199,84 @@ func main() {
         version = "1.0.0"
     }
 
+    // Load balancing across service instances: round_robin (default) or least_outstanding
+    lb, err := newBalancer(os.Getenv("LB_STRATEGY"))
+    if err != nil {
+        logger.WithError(err).Fatal("Invalid LB_STRATEGY")
+    }
+
+    // Circuit breaker applied to every service instance
+    breakerCfg := breakerConfig{
+        FailureThreshold: envInt(logger, "BREAKER_FAILURE_THRESHOLD", 5),
+        OpenTimeout:      envDuration(logger, "BREAKER_OPEN_TIMEOUT", 30*time.Second),
+        HalfOpenRequests: envInt(logger, "BREAKER_HALF_OPEN_REQUESTS", 1),
+    }
+
+    // Retries of idempotent requests, bounded by a per-route retry budget
+    retryCfg := retryConfig{
+        MaxAttempts:        envInt(logger, "RETRY_MAX_ATTEMPTS", 3),
+        BaseBackoff:        envDuration(logger, "RETRY_BASE_BACKOFF", 50*time.Millisecond),
+        MaxBackoff:         envDuration(logger, "RETRY_MAX_BACKOFF", time.Second),
+        BudgetRatio:        envFloat(logger, "RETRY_BUDGET_RATIO", 0.2),
+        BudgetMinPerSecond: envFloat(logger, "RETRY_BUDGET_MIN_PER_SECOND", 1),
+    }
+    if err := retryCfg.validate(); err != nil {
+        logger.WithError(err).Fatal("Invalid retry settings")
+    }
+
     // Init discovery
-    discovery := NewDiscovery(registryURL, 20*time.Second, logger)
-
-    // Rate limiter: 60 req/min => 1 req per second burst 60
-    rl := newIPRateLimiter(1, 60) // limit 1 token per second, burst 60
-
-    // Route mappings
-    mappings := []routeMapping{
-        {
-            Prefix:        "/api/v1/auth",
-            ServiceName:   "auth-service",
-            RewritePrefix: "/auth",
-            AuthRequired:  false,
-        },
-        {
-            Prefix:        "/api/v1/posts",
-            ServiceName:   "post-service",
-            RewritePrefix: "/post",
-            AuthRequired:  false, // specific endpoints will check internally
-        },
-        {
-            Prefix:        "/api/v1/comments",
-            ServiceName:   "comment-service",
-            RewritePrefix: "/comment",
-            AuthRequired:  false,
-        },
-        {
-            Prefix:        "/api/v1/profile",
-            ServiceName:   "user-profile-service",
-            RewritePrefix: "/profile",
-            AuthRequired:  true,
-        },
+    discovery := NewDiscovery(registryURL, 20*time.Second, breakerCfg, logger)
+
+    // Routes and rate limit policies, reloaded on SIGHUP or file change
+    configFile := os.Getenv("GATEWAY_CONFIG")
+    if configFile == "" {
+        configFile = "gateway.yaml"
+    }
+    routes, err := newRouteStore(configFile, logger)
+    if err != nil {
+        logger.WithError(err).Fatal("Failed to load gateway configuration")
     }
+    routes.watch(envDuration(logger, "GATEWAY_CONFIG_POLL_INTERVAL", 5*time.Second))
+
+    // Probe the health endpoint of every instance; failing instances are
+    // taken out of rotation until they recover
+    newHealthChecker(healthCheckConfig{
+        Interval:           envDuration(logger, "HEALTH_CHECK_INTERVAL", 10*time.Second),
+        Timeout:            envDuration(logger, "HEALTH_CHECK_TIMEOUT", 2*time.Second),
+        UnhealthyThreshold: envInt(logger, "HEALTH_CHECK_UNHEALTHY_THRESHOLD", 2),
+        HealthyThreshold:   envInt(logger, "HEALTH_CHECK_HEALTHY_THRESHOLD", 2),
+    }, discovery, routes, logger).start()
+
+    initMetrics("api-gateway")
+
+    shutdownTracing, err := tracing.Init(context.Background(), "api-gateway")
+    if err != nil {
+        logger.WithError(err).Fatal("Failed to initialize tracing")
+    }
+    defer shutdownTracing(context.Background())
 
     router := gin.New()
     router.Use(gin.Recovery())
 
+    // Client IPs, which blocks and rate limits apply to, are only taken from
+    // X-Forwarded-For when the request comes through a trusted proxy;
+    // otherwise any client could claim any address
+    trustedProxies := envList("TRUSTED_PROXIES")
+    if err := router.SetTrustedProxies(trustedProxies); err != nil {
+        logger.WithError(err).Fatal("Invalid TRUSTED_PROXIES")
+    }
+
+    // Resolve the route of every request before authentication and logging
+    router.Use(routeMiddleware(routes))
+
+    // Stream requests may carry their token in the query string, which must
+    // not reach traces, logs or upstreams
+    router.Use(streamTokenMiddleware())
+
+    // Continue the caller's trace, or start one, for every request
+    router.Use(otelgin.Middleware("api-gateway", otelgin.WithSpanNameFormatter(spanName)))
+    router.Use(metricsMiddleware())
+
     // Initialize Redis client for blacklist checks
     redisAddr := os.Getenv("REDIS_ADDR")
     if redisAddr == "" {
@@ -405,9 +284,29 @@ func main() {
     }
 
     redisClient := redis.NewClient(&redis.Options{Addr: redisAddr})
+    registerRedisMetrics(redisClient, "redis")
+
+    // Rate limits are shared by all gateway replicas through Redis, with an
+    // in-memory fallback while Redis is unreachable
+    rl := newRedisRateLimiter(redisClient, newLocalRateLimiter(10*time.Minute, 100000), logger)
+
+    // Auth levels of the gateway's own endpoints; proxied routes declare
+    // theirs in the route configuration
+    localAuth := localAuthPolicies{
+        "/health":          authAnonymous,
+        "/health/detailed": authAnonymous,
+        "/health/ready":    authAnonymous,
+        "/api/docs":              authAnonymous,
+        "/api/docs/openapi.json": authAnonymous,
+        "/metrics":         authAnonymous,
+        // Composed views are public, but callers may be identified
+        "/api/v1/views/post/:id": authOptional,
+        // GraphQL resolvers that need a user check for one themselves
+        "/api/graphql": authOptional,
+    }
 
     // Global authentication middleware
-    router.Use(jwtAuthMiddleware(jwtSecret, redisClient))
+    router.Use(jwtAuthMiddleware(jwtSecret, redisClient, localAuth))
 
     // Logging middleware
     router.Use(func(c *gin.Context) {
@@ -421,23 +320,44 @@ func main() {
             "ip":       c.ClientIP(),
             "latency":  latency.String(),
             "userAgent": c.Request.UserAgent(),
+            "upstream": c.GetString("upstream_instance"),
+            "upstream_version": c.GetString("upstream_version"),
+            "api_key": c.GetString("api_key_id"),
+            "fault": c.GetString("fault_id"),
+            "trace_id": trace.SpanContextFromContext(c.Request.Context()).TraceID().String(),
         }).Info("request completed")
     })
 
-    // Rate limiting middleware
-    router.Use(func(c *gin.Context) {
-        ip := c.ClientIP()
-        limiter := rl.getLimiter(ip)
-        if !limiter.Allow() {
-            c.JSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
-            c.Abort()
-            return
-        }
-        c.Next()
-    })
+    // IP ranges and users blocked through the admin API, shared by all
+    // gateway replicas through Redis
+    blocks := newBlockList(redisClient, logger)
+    blocks.start(context.Background(), envDuration(logger, "BLOCKS_REFRESH_INTERVAL", 10*time.Second))
+    router.Use(blockMiddleware(blocks))
+
+    // Faults injected through the admin API for resilience testing. Opt-in,
+    // and never in production
+    var faults *faultInjector
+    switch {
+    case os.Getenv("FAULT_INJECTION_ENABLED") != "true":
+    case environment == "production":
+        logger.Warn("FAULT_INJECTION_ENABLED is ignored in production")
+    default:
+        faults = newFaultInjector()
+        router.Use(faultMiddleware(faults))
+        logger.Warn("Fault injection enabled")
+    }
+
+    // Compress JSON responses for clients that accept it
+    router.Use(compressionMiddleware(envInt(logger, "COMPRESSION_MIN_SIZE", 1024)))
+
+    // Rate limiting of the gateway's own endpoints; proxied routes apply
+    // their own policies
+    defaultRateLimit := rateLimitMiddleware(rl, func(c *gin.Context) rateLimitPolicy {
+        return routeTableFromContext(c).policies[defaultRateLimitPolicy]
+    }, logger)
 
     // Enhanced health endpoint for gateway
-    router.GET("/health", func(c *gin.Context) {
+    router.GET("/health", defaultRateLimit, func(c *gin.Context) {
         uptime := time.Since(startTime)
         
         healthStatus := HealthStatus{
@@ -455,13 +375,26 @@ func main() {
         c.JSON(http.StatusOK, healthStatus)
     })
 
+    // Readiness endpoint for load balancers, failing once the gateway begins
+    // to shut down so that traffic moves elsewhere before it stops. /health
+    // keeps reporting liveness until the process exits.
+    var draining atomic.Bool
+    router.GET("/health/ready", func(c *gin.Context) {
+        if draining.Load() {
+            c.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining"})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"status": "ready"})
+    })
+
     // Detailed health endpoint
-    router.GET("/health/detailed", func(c *gin.Context) {
+    router.GET("/health/detailed", defaultRateLimit, func(c *gin.Context) {
         uptime := time.Since(startTime)
         services := discovery.GetAllServices()
         
-        // Check if critical services are available
-        criticalServices := []string{"auth-service", "post-service", "comment-service", "user-profile-service"}
+        // Check if the services behind the configured routes are available
+        table := routeTableFromContext(c)
+        criticalServices := table.services()
         missingServices := []string{}
         
         for _, service := range criticalServices {
@@ -470,8 +403,30 @@ func main() {
             }
         }
         
+        // Services none of whose instances pass their health checks
+        instanceHealth := discovery.HealthStatus()
+        unhealthyServices := []string{}
+        for name, instances := range instanceHealth {
+            healthy := false
+            for _, h := range instances {
+                healthy = healthy || h.Healthy
+            }
+            if !healthy {
+                unhealthyServices = append(unhealthyServices, name)
+            }
+        }
+
+        // Services whose every instance has an open circuit cannot serve traffic
+        breakers := discovery.BreakerStatus()
+        openCircuits := []string{}
+        for name, b := range breakers {
+            if b.State == breakerOpen.String() {
+                openCircuits = append(openCircuits, name)
+            }
+        }
+
         status := "healthy"
-        if len(missingServices) > 0 {
+        if len(missingServices) > 0 || len(unhealthyServices) > 0 || len(openCircuits) > 0 {
             status = "degraded"
         }
         
@@ -487,11 +442,20 @@ func main() {
             "services": gin.H{
                 "available": services,
                 "missing":   missingServices,
+                "unhealthy": unhealthyServices,
+                "circuit_open": openCircuits,
                 "count":     len(services),
             },
             "rate_limiter": gin.H{
-                "enabled": true,
-                "limit":   "60 requests per minute",
+                "enabled":  true,
+                "policies": rateLimitSummary(table.policies),
+                "backend":  rl.Backend(),
+            },
+            "instances":        instanceHealth,
+            "circuit_breakers": breakers,
+            "routes": gin.H{
+                "count":     len(table.routes),
+                "loaded_at": table.loadedAt,
             },
         }
         
@@ -502,21 +466,136 @@ func main() {
         }
     })
 
-    // Register proxy handlers
-    for _, m := range mappings {
-        // path with wildcard
-        pattern := m.Prefix + "/*action"
-        router.Any(pattern, makeProxyHandler(m, discovery, logger))
-        // Add root path handler
-        if m.Prefix == "/api/v1/posts" {
-            router.Any(m.Prefix, makeProxyHandler(m, discovery, logger))
+    // Prometheus metrics
+    router.GET("/metrics", metricsHandler())
+
+    // ETags for GET responses; fresh ones are revalidated without the upstream
+    validators := newValidatorCache(envInt(logger, "ETAG_CACHE_ENTRIES", 10000))
+    conditionalGet := conditionalGetMiddleware(validators, envInt(logger, "ETAG_MAX_BODY_SIZE", 1<<20))
+
+    // Anonymous reads are cached as their Cache-Control allows, in Redis
+    // with an in-memory copy; services purge them by surrogate key
+    responses := newResponseCache(redisClient,
+        envInt(logger, "RESPONSE_CACHE_LOCAL_ENTRIES", 1000),
+        envInt(logger, "RESPONSE_CACHE_MAX_BODY_SIZE", 1<<20),
+        validators, logger)
+    responses.subscribe(context.Background())
+
+    // Composed views, gathered from several services in one response
+    views := newViewComposer(discovery, lb, identity, logger)
+    router.GET("/api/v1/views/post/:id", defaultRateLimit, conditionalGet, views.postViewHandler())
+
+    // GraphQL over the same service APIs
+    gql, err := newGraphQLAPI(views, queryLimits{
+        MaxDepth: envInt(logger, "GRAPHQL_MAX_DEPTH", 8),
+        MaxCost:  envInt(logger, "GRAPHQL_MAX_COST", 1000),
+    }, logger)
+    if err != nil {
+        logger.WithError(err).Fatal("Failed to build GraphQL schema")
+    }
+    router.GET("/api/graphql", defaultRateLimit, gql.handler())
+    router.POST("/api/graphql", defaultRateLimit, gql.handler())
+
+    // OpenAPI documentation of the services, under the public prefixes
+    docs := newAPIDocs(discovery, routes, envDuration(logger, "DOCS_CACHE_TTL", time.Minute), version, logger)
+    router.GET("/api/docs", defaultRateLimit, docs.uiHandler("/api/docs/openapi.json"))
+    router.GET("/api/docs/openapi.json", defaultRateLimit, docs.documentHandler())
+    // Kept fetched for request validation; missing documents are retried
+    // on every tick
+    docs.start(10 * time.Second)
+
+    // Every other request is proxied according to the route table
+    streams := newStreamLimiter(envInt(logger, "MAX_STREAMS_PER_USER", 5))
+    routeRateLimit := rateLimitMiddleware(rl, func(c *gin.Context) rateLimitPolicy {
+        return routeTableFromContext(c).policies.forRequest(routeFromContext(c), c.Request.Method, c.Request.URL.Path)
+    }, logger)
+    proxy := makeProxyHandler(discovery, lb, retryCfg, identity, streams, logger)
+    router.NoRoute(routeGuard(), routeRateLimit, requestValidationMiddleware(docs), conditionalGet, responseCacheMiddleware(responses), proxy)
+
+    // Stale responses are refetched through the proxy alone, skipping the rate
+    // limits, injected faults and the cache itself
+    responses.revalidateThrough(proxy)
+
+    if err := localAuth.check(router.Routes()); err != nil {
+        logger.WithError(err).Fatal("Invalid gateway auth policies")
+    }
+
+    // Admin API on its own listener, enabled by setting a token
+    var adminSrv *http.Server
+    if adminToken := os.Getenv("ADMIN_TOKEN"); adminToken != "" {
+        adminPort := os.Getenv("ADMIN_PORT")
+        if adminPort == "" {
+            adminPort = "9091"
+        }
+        admin := &adminAPI{
+            token:       adminToken,
+            disc:        discovery,
+            routes:      routes,
+            rl:          rl,
+            cache:       responses,
+            blocks:      blocks,
+            faults:      faults,
+            version:     version,
+            environment: environment,
+            logger:      logger,
         }
+        adminSrv = &http.Server{
+            Addr:    ":" + adminPort,
+            Handler: admin.router(),
+        }
+        go func() {
+            logger.Infof("Admin API listening on :%s", adminPort)
+            if err := adminSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+                logger.WithError(err).Fatal("Admin API failed")
+            }
+        }()
+    } else {
+        logger.Info("ADMIN_TOKEN not set; admin API disabled")
     }
 
     // Start server
-    addr := fmt.Sprintf(":%s", port)
-    logger.Infof("API Gateway listening on %s", addr)
-    if err := router.Run(addr); err != nil {
-        logger.WithError(err).Fatal("Failed to start API Gateway")
+    srv := &http.Server{
+        Addr:    fmt.Sprintf(":%s", port),
+        Handler: router,
+    }
+    go func() {
+        logger.Infof("API Gateway listening on %s", srv.Addr)
+        if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+            logger.WithError(err).Fatal("Failed to start API Gateway")
+        }
+    }()
+
+    quit := make(chan os.Signal, 1)
+    signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+    <-quit
+
+    // Fail readiness and keep serving while load balancers take the gateway
+    // out of rotation; clients are told not to reuse their connections
+    drainDelay := envDuration(logger, "SHUTDOWN_DRAIN_DELAY", 5*time.Second)
+    logger.Infof("Shutting down; draining for %s", drainDelay)
+    draining.Store(true)
+    srv.SetKeepAlivesEnabled(false)
+    time.Sleep(drainDelay)
+
+    // Stop accepting connections and wait for in-flight requests. Streams
+    // never finish on their own, so they are closed first.
+    ctx, cancel := context.WithTimeout(context.Background(), envDuration(logger, "SHUTDOWN_TIMEOUT", 30*time.Second))
+    defer cancel()
+    streams.closeAll()
+    // Both listeners drain at once, and the gateway exits once both are done
+    var wg sync.WaitGroup
+    if adminSrv != nil {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            if err := adminSrv.Shutdown(ctx); err != nil {
+                logger.WithError(err).Error("Admin API forced to shut down")
+            }
+        }()
+    }
+    if err := srv.Shutdown(ctx); err != nil {
+        logger.WithError(err).Error("API Gateway forced to shut down")
     }
+    wg.Wait()
+    logger.Info("API Gateway stopped")
 }
\ No newline at end of file
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/omsurase/blogger_microservices/server/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+// ----------------------------------
+// Metrics
+// ----------------------------------
+
+// The gateway's metrics come on top of those every service exports; see the
+// metrics package.
+
+var (
+	upstreamErrors     *prometheus.CounterVec
+	rateLimitRejection *prometheus.CounterVec
+)
+
+// initMetrics registers the gateway's metrics under serviceName. It must be
+// called before any other metrics function.
+func initMetrics(serviceName string) {
+	metrics.Init(serviceName)
+
+	upstreamErrors = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_upstream_errors_total",
+		Help: "Failed attempts to reach an upstream service, by service and reason.",
+	}, "upstream", "reason")
+	rateLimitRejection = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_rate_limit_rejections_total",
+		Help: "Requests rejected by rate limiting, by route and policy.",
+	}, "route", "policy")
+}
+
+// Reasons for upstream errors.
+const (
+	upstreamNoInstances = "no_instances" // no instance is registered
+	upstreamCircuitOpen = "circuit_open" // every instance's breaker is open
+	upstreamBadStatus   = "bad_status"   // the instance answered 502, 503 or 504
+	upstreamTimeout     = "timeout"      // the request deadline passed
+	upstreamConnection  = "connection"   // any other transport error
+)
+
+func upstreamError(service, reason string) {
+	upstreamErrors.WithLabelValues(service, reason).Inc()
+}
+
+func rateLimitRejected(c *gin.Context, policy string) {
+	rateLimitRejection.WithLabelValues(metricsRoute(c), policy).Inc()
+}
+
+// metricsRoute labels a request with the gateway endpoint or the proxied
+// route prefix. Requests that match neither are labelled "unmatched" to keep
+// label cardinality bounded.
+func metricsRoute(c *gin.Context) string {
+	if path := c.FullPath(); path != "" {
+		return path
+	}
+	if route := routeFromContext(c); route != nil {
+		return route.Prefix
+	}
+	return "unmatched"
+}
+
+// metricsMiddleware records the count and latency of every request. It relies
+// on routeMiddleware having run.
+func metricsMiddleware() gin.HandlerFunc {
+	return metrics.MiddlewareWithRoute(metricsRoute)
+}
+
+// metricsHandler serves the metrics in the Prometheus exposition format.
+func metricsHandler() gin.HandlerFunc {
+	return gin.WrapH(metrics.Handler())
+}
+
+// registerRedisMetrics exports the connection pool statistics of a Redis
+// client.
+func registerRedisMetrics(client *redis.Client, name string) {
+	metrics.RegisterRedis(func() metrics.RedisPoolStats {
+		return metrics.RedisPoolStats(*client.PoolStats())
+	}, name)
+}
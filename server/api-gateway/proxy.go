@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// ----------------------------------
+// Gateway handler factory
+// ----------------------------------
+
+// errRetryableStatus is returned from ModifyResponse to discard an upstream
+// failure response that is about to be retried on another attempt.
+var errRetryableStatus = errors.New("upstream returned a retryable status")
+
+// makeProxyHandler forwards requests to the service of the route resolved by
+// routeMiddleware, with the caller's identity attached by signer. SSE and
+// WebSocket requests count against the caller's streams in streams.
+func makeProxyHandler(disc *Discovery, lb balancer, retries retryConfig, signer *identitySigner, streams *streamLimiter, logger *logrus.Logger) gin.HandlerFunc {
+	// Retry budgets are kept per route prefix so that they survive reloads
+	var budgets sync.Map
+
+	return func(c *gin.Context) {
+		// No JWT handling here – performed globally.
+		mapping := routeFromContext(c)
+		b, _ := budgets.LoadOrStore(mapping.Prefix, newRetryBudget(retries))
+		budget := b.(*retryBudget)
+
+		// Lookup service instances
+		if len(disc.Instances(mapping.ServiceName)) == 0 {
+			logger.Errorf("service %s not found in registry", mapping.ServiceName)
+			upstreamError(mapping.ServiceName, upstreamNoInstances)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Service unavailable"})
+			return
+		}
+
+		kind := streamKindOf(c.Request)
+		if kind != streamNone {
+			if streams.closing() {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Gateway is shutting down"})
+				return
+			}
+			release, ok := streams.acquire(c)
+			if !ok {
+				c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many open streams"})
+				return
+			}
+			defer release()
+		}
+
+		// Apply per-request timeout, shared by all attempts. Streams stay
+		// open until either side closes them.
+		ctx := c.Request.Context()
+		if kind == streamNone {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, mapping.timeout())
+			defer cancel()
+			c.Request = c.Request.WithContext(ctx)
+		}
+
+		var body *trackedBody
+		if c.Request.Body != nil && c.Request.Body != http.NoBody {
+			body = newTrackedBody(c.Request.Body)
+			c.Request.Body = body
+		}
+		idempotent := isIdempotent(c.Request)
+		budget.deposit()
+
+		// Version rules pick the release once, so retries stay on it
+		version := mapping.selectVersion(c)
+
+		tried := make(map[*upstreamInstance]bool)
+		for attempt := 1; ; attempt++ {
+			// Only instances whose circuit breaker is not open are eligible, and
+			// a retry prefers an instance that has not failed this request yet.
+			available := mapping.versionInstances(disc.AvailableInstances(mapping.ServiceName), version)
+			candidates := mapping.versionInstances(disc.Instances(mapping.ServiceName), version)
+			if len(available) == 0 && !breakersOpen(candidates) {
+				// Drained, disabled or unhealthy instances do not come back
+				// when a breaker closes, so there is no time to retry after
+				respondNoInstances(c, mapping.ServiceName, version, logger)
+				return
+			}
+			instance := pickInstance(lb, mapping.ServiceName, available, tried)
+			if instance == nil {
+				respondCircuitOpen(c, mapping.ServiceName, candidates, logger)
+				return
+			}
+			tried[instance] = true
+
+			mayRetry := func() bool {
+				return idempotent &&
+					attempt < retries.MaxAttempts &&
+					!body.consumed() &&
+					ctx.Err() == nil &&
+					budget.withdraw()
+			}
+			if !forwardToInstance(c, mapping, instance, kind, signer, mayRetry, logger) {
+				return
+			}
+
+			delay := retries.backoff(attempt)
+			logger.WithFields(logrus.Fields{
+				"service":  mapping.ServiceName,
+				"instance": instance.ID,
+				"attempt":  attempt,
+				"backoff":  delay.String(),
+			}).Warn("retrying proxied request")
+
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				respondBadGateway(c.Writer)
+				return
+			}
+		}
+	}
+}
+
+// proxyAttempt is the state of one attempt to forward a request, carried to
+// an instance's shared reverse proxy through the request context.
+type proxyAttempt struct {
+	c        *gin.Context
+	mapping  *routeMapping
+	instance *upstreamInstance
+	signer   *identitySigner
+	mayRetry func() bool
+
+	// retry is set when the attempt failed without writing a response and
+	// mayRetry allowed another attempt
+	retry bool
+}
+
+type proxyAttemptKey struct{}
+
+func proxyAttemptFrom(req *http.Request) *proxyAttempt {
+	return req.Context().Value(proxyAttemptKey{}).(*proxyAttempt)
+}
+
+// forwardToInstance proxies the request to a single instance. It returns true
+// when the attempt failed without writing a response and mayRetry allowed
+// another attempt; otherwise the response has been written.
+func forwardToInstance(c *gin.Context, mapping *routeMapping, instance *upstreamInstance, kind streamKind, signer *identitySigner, mayRetry func() bool, logger *logrus.Logger) (retry bool) {
+	done := instance.acquire()
+	defer done()
+	c.Set("upstream_instance", instance.ID)
+	c.Set("upstream_version", instance.Version)
+
+	proxy, err := instance.proxy(mapping.Transport, kind != streamNone, logger)
+	if err != nil {
+		instance.breaker.Ignore()
+		logger.WithError(err).Error("invalid service address")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid service address"})
+		return false
+	}
+
+	if kind != streamNone {
+		// The copy of a stream ends with an aborted handler when the client
+		// goes away, which is how streams normally end
+		defer func() {
+			if r := recover(); r != nil && r != http.ErrAbortHandler {
+				panic(r)
+			}
+		}()
+	}
+
+	attempt := &proxyAttempt{c: c, mapping: mapping, instance: instance, signer: signer, mayRetry: mayRetry}
+	proxy.ServeHTTP(c.Writer, c.Request.WithContext(context.WithValue(c.Request.Context(), proxyAttemptKey{}, attempt)))
+	return attempt.retry
+}
+
+// newReverseProxy builds the long-lived proxy of an instance. Everything
+// specific to a request comes from its proxyAttempt.
+func newReverseProxy(target *url.URL, transport http.RoundTripper, flushInterval time.Duration, logger *logrus.Logger) *httputil.ReverseProxy {
+	director := func(req *http.Request) {
+		a := proxyAttemptFrom(req)
+		req.URL.Scheme = target.Scheme
+		req.URL.Host = target.Host
+		// rewrite path
+		incomingPath := req.URL.Path
+		trimmed := strings.TrimPrefix(incomingPath, a.mapping.Prefix)
+		newPath := a.mapping.RewritePrefix + trimmed
+		if !strings.HasPrefix(newPath, "/") {
+			newPath = "/" + newPath
+		}
+		req.URL.Path = newPath
+		req.URL.RawPath = newPath
+		// retain query
+		// headers already present
+		req.Host = target.Host
+		// Assertions are signed per attempt so that a late retry does not
+		// carry an expired one
+		if err := a.signer.attachIdentity(req, a.mapping.ServiceName, a.c); err != nil {
+			logger.WithError(err).Error("failed to sign identity assertion")
+		}
+	}
+
+	return &httputil.ReverseProxy{
+		Director:      director,
+		Transport:     transport,
+		FlushInterval: flushInterval,
+		ModifyResponse: func(resp *http.Response) error {
+			a := proxyAttemptFrom(resp.Request)
+			if isUpstreamFailure(resp.StatusCode) {
+				a.instance.breaker.Failure()
+				upstreamError(a.mapping.ServiceName, upstreamBadStatus)
+				if a.mayRetry() {
+					return errRetryableStatus
+				}
+				return nil
+			}
+			a.instance.breaker.Success()
+			// Surrogate keys tag the response for cache purges and are not
+			// meant for clients
+			if keys := resp.Header.Get("Surrogate-Key"); keys != "" {
+				a.c.Set("surrogate_keys", strings.Fields(keys))
+				resp.Header.Del("Surrogate-Key")
+			}
+			if resp.StatusCode == http.StatusSwitchingProtocols {
+				// The upgraded connection is hijacked without going through
+				// gin, so record the status for logs and metrics here
+				a.c.Status(http.StatusSwitchingProtocols)
+			}
+			return nil
+		},
+		ErrorHandler: func(rw http.ResponseWriter, r *http.Request, e error) {
+			a := proxyAttemptFrom(r)
+			switch {
+			case errors.Is(e, errRetryableStatus):
+				// Already recorded against the breaker in ModifyResponse
+				a.retry = true
+				return
+			case errors.Is(e, context.Canceled):
+				// A request the client gave up on says nothing about the upstream
+				a.instance.breaker.Ignore()
+			default:
+				a.instance.breaker.Failure()
+				upstreamError(a.mapping.ServiceName, transportErrorReason(e))
+			}
+			logger.WithError(e).WithField("instance", a.instance.ID).Error("proxy error")
+			if a.mayRetry() {
+				a.retry = true
+				return
+			}
+			respondBadGateway(rw)
+		},
+	}
+}
+
+// pickInstance picks an instance whose circuit breaker admits the request,
+// preferring those not tried yet. An instance whose half-open breaker refuses
+// because its probes are already in flight is passed over for another one.
+func pickInstance(lb balancer, service string, instances []*upstreamInstance, tried map[*upstreamInstance]bool) *upstreamInstance {
+	refused := make(map[*upstreamInstance]bool)
+	for {
+		eligible := filterInstances(instances, func(inst *upstreamInstance) bool { return !refused[inst] })
+		if len(eligible) == 0 {
+			return nil
+		}
+		instance := lb.Pick(service, untriedInstances(eligible, tried))
+		if instance == nil || instance.breaker.Allow() {
+			return instance
+		}
+		refused[instance] = true
+	}
+}
+
+// untriedInstances returns the instances not yet tried for this request, or
+// all of them if every instance has been tried already.
+func untriedInstances(instances []*upstreamInstance, tried map[*upstreamInstance]bool) []*upstreamInstance {
+	if len(tried) == 0 {
+		return instances
+	}
+	untried := make([]*upstreamInstance, 0, len(instances))
+	for _, inst := range instances {
+		if !tried[inst] {
+			untried = append(untried, inst)
+		}
+	}
+	if len(untried) == 0 {
+		return instances
+	}
+	return untried
+}
+
+// breakersOpen reports whether the circuit breaker of any of instances keeps
+// requests from it.
+func breakersOpen(instances []*upstreamInstance) bool {
+	for _, inst := range instances {
+		if !inst.breaker.Ready() {
+			return true
+		}
+	}
+	return false
+}
+
+// breakersRetryAfter returns the shortest time until one of the open breakers
+// of instances admits probes again. When none is open, as when every
+// half-open breaker has its probes in flight, it is a full open timeout.
+func breakersRetryAfter(instances []*upstreamInstance) time.Duration {
+	var wait time.Duration
+	open := false
+	for _, inst := range instances {
+		if ra, ok := inst.breaker.RetryAfter(); ok && (!open || ra < wait) {
+			wait, open = ra, true
+		}
+	}
+	if !open && len(instances) > 0 {
+		wait = instances[0].breaker.cfg.OpenTimeout
+	}
+	return wait
+}
+
+// respondNoInstances rejects a request because no instance of the service,
+// or of the version chosen for it, is active and healthy.
+func respondNoInstances(c *gin.Context, service, version string, logger *logrus.Logger) {
+	logger.Warnf("no available instances of service %s", service)
+	upstreamError(service, upstreamNoInstances)
+	message := "Service unavailable"
+	if version != "" {
+		message = "No instances of version " + version + " available"
+	}
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": message})
+}
+
+// respondCircuitOpen rejects a request because the circuit breakers of all
+// the candidate instances for it refuse requests.
+func respondCircuitOpen(c *gin.Context, service string, candidates []*upstreamInstance, logger *logrus.Logger) {
+	logger.Warnf("circuit open for service %s", service)
+	upstreamError(service, upstreamCircuitOpen)
+	retryAfter := int(math.Ceil(breakersRetryAfter(candidates).Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Service temporarily unavailable"})
+}
+
+// transportErrorReason classifies a transport error for metrics.
+func transportErrorReason(err error) string {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return upstreamTimeout
+	}
+	return upstreamConnection
+}
+
+func respondBadGateway(rw http.ResponseWriter) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusBadGateway)
+	rw.Write([]byte(`{"error":"Bad gateway"}`))
+}
+
+// isUpstreamFailure reports whether a response status means the upstream could
+// not serve the request, as opposed to rejecting it.
+func isUpstreamFailure(status int) bool {
+	return status == http.StatusBadGateway ||
+		status == http.StatusServiceUnavailable ||
+		status == http.StatusGatewayTimeout
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// ----------------------------------
+// Rate limiting
+// ----------------------------------
+
+// rateLimitPolicy allows Requests per Period, with bursts of up to Burst
+// requests (Requests when unset).
+type rateLimitPolicy struct {
+	Name     string        `yaml:"-"`
+	Requests int           `yaml:"requests"`
+	Period   time.Duration `yaml:"period"`
+	Burst    int           `yaml:"burst"`
+}
+
+func (p rateLimitPolicy) burst() int {
+	if p.Burst > 0 {
+		return p.Burst
+	}
+	return p.Requests
+}
+
+// emissionInterval is the time it takes to earn back one request.
+func (p rateLimitPolicy) emissionInterval() time.Duration {
+	return p.Period / time.Duration(p.Requests)
+}
+
+func (p rateLimitPolicy) String() string {
+	return fmt.Sprintf("%d requests per %s", p.Requests, p.Period)
+}
+
+type rateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long a rejected caller has to wait for its next request.
+	RetryAfter time.Duration
+	// Reset is how long until the caller's full burst is available again.
+	Reset time.Duration
+}
+
+type rateLimiter interface {
+	Allow(ctx context.Context, key string, policy rateLimitPolicy) rateLimitResult
+}
+
+// gcraScript implements the generic cell rate algorithm. The only state per
+// key is the theoretical arrival time (TAT) of the next request, stored in
+// milliseconds and expiring once the caller has earned back its full burst.
+// Redis' own clock is used so that every gateway replica agrees on "now".
+//
+// KEYS[1] = key, ARGV[1] = emission interval (ms), ARGV[2] = burst.
+// Returns {allowed, remaining, retry_after_ms, reset_ms}.
+var gcraScript = redis.NewScript(`
+local emission = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local tat = tonumber(redis.call('GET', KEYS[1]))
+if not tat or tat < now then
+  tat = now
+end
+
+local tolerance = emission * burst
+local new_tat = tat + emission
+local allow_at = new_tat - tolerance
+if allow_at > now then
+  return {0, 0, allow_at - now, tat - now}
+end
+
+redis.call('SET', KEYS[1], new_tat, 'PX', new_tat - now)
+local remaining = math.floor((now + tolerance - new_tat) / emission)
+return {1, remaining, 0, new_tat - now}
+`)
+
+// redisRateLimiter shares rate limit state between all gateway replicas
+// through Redis. While Redis is unreachable it falls back to a local limiter,
+// and only tries Redis again after retryInterval.
+type redisRateLimiter struct {
+	client        *redis.Client
+	fallback      *localRateLimiter
+	timeout       time.Duration
+	retryInterval time.Duration
+	logger        *logrus.Logger
+
+	degradedUntil atomic.Int64 // unix nanoseconds
+	degraded      atomic.Bool
+}
+
+func newRedisRateLimiter(client *redis.Client, fallback *localRateLimiter, logger *logrus.Logger) *redisRateLimiter {
+	return &redisRateLimiter{
+		client:        client,
+		fallback:      fallback,
+		timeout:       100 * time.Millisecond,
+		retryInterval: 5 * time.Second,
+		logger:        logger,
+	}
+}
+
+func (l *redisRateLimiter) Allow(ctx context.Context, key string, policy rateLimitPolicy) rateLimitResult {
+	if time.Now().UnixNano() < l.degradedUntil.Load() {
+		return l.fallback.Allow(ctx, key, policy)
+	}
+
+	scriptCtx, cancel := context.WithTimeout(ctx, l.timeout)
+	defer cancel()
+
+	redisKey := fmt.Sprintf("ratelimit:%s:%s", policy.Name, key)
+	emission := policy.emissionInterval().Milliseconds()
+	if emission < 1 {
+		emission = 1
+	}
+	res, err := gcraScript.Run(scriptCtx, l.client, []string{redisKey}, emission, policy.burst()).Int64Slice()
+	if err != nil && ctx.Err() != nil {
+		// The caller went away, which says nothing about Redis
+		return l.fallback.Allow(ctx, key, policy)
+	}
+	if err != nil || len(res) != 4 {
+		if err == nil {
+			err = fmt.Errorf("unexpected script result %v", res)
+		}
+		l.degradedUntil.Store(time.Now().Add(l.retryInterval).UnixNano())
+		if !l.degraded.Swap(true) {
+			l.logger.WithError(err).Warn("Redis rate limiter unavailable; falling back to local rate limiting")
+		}
+		return l.fallback.Allow(ctx, key, policy)
+	}
+	if l.degraded.Swap(false) {
+		l.logger.Info("Redis rate limiter recovered")
+	}
+
+	return rateLimitResult{
+		Allowed:    res[0] == 1,
+		Limit:      policy.Requests,
+		Remaining:  int(res[1]),
+		RetryAfter: time.Duration(res[2]) * time.Millisecond,
+		Reset:      time.Duration(res[3]) * time.Millisecond,
+	}
+}
+
+// Backend reports which limiter is currently making decisions.
+func (l *redisRateLimiter) Backend() string {
+	if l.degraded.Load() {
+		return "local"
+	}
+	return "redis"
+}
+
+// localRateLimiter keeps one token bucket per key in memory. Buckets that
+// have been idle for idleTTL are evicted, and at most maxEntries are kept, so
+// memory stays bounded no matter how many distinct clients show up.
+type localRateLimiter struct {
+	idleTTL    time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	buckets map[string]*localBucket
+}
+
+type localBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newLocalRateLimiter(idleTTL time.Duration, maxEntries int) *localRateLimiter {
+	l := &localRateLimiter{
+		idleTTL:    idleTTL,
+		maxEntries: maxEntries,
+		buckets:    make(map[string]*localBucket),
+	}
+	go l.evictLoop()
+	return l
+}
+
+// Len returns the number of buckets currently kept.
+func (l *localRateLimiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+func (l *localRateLimiter) evictLoop() {
+	ticker := time.NewTicker(l.idleTTL / 2)
+	for range ticker.C {
+		l.mu.Lock()
+		l.evictIdle(time.Now())
+		l.mu.Unlock()
+	}
+}
+
+// evictIdle drops buckets unused for idleTTL. The caller must hold l.mu.
+func (l *localRateLimiter) evictIdle(now time.Time) {
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) > l.idleTTL {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// evictOldest drops the least recently used bucket. The caller must hold l.mu.
+func (l *localRateLimiter) evictOldest() {
+	var oldestKey string
+	var oldest time.Time
+	for key, b := range l.buckets {
+		if oldestKey == "" || b.lastSeen.Before(oldest) {
+			oldestKey, oldest = key, b.lastSeen
+		}
+	}
+	delete(l.buckets, oldestKey)
+}
+
+func (l *localRateLimiter) Allow(_ context.Context, key string, policy rateLimitPolicy) rateLimitResult {
+	now := time.Now()
+	key = policy.Name + ":" + key
+
+	l.mu.Lock()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= l.maxEntries {
+			l.evictIdle(now)
+			if len(l.buckets) >= l.maxEntries {
+				l.evictOldest()
+			}
+		}
+		b = &localBucket{limiter: rate.NewLimiter(rate.Every(policy.emissionInterval()), policy.burst())}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+	l.mu.Unlock()
+
+	res := rateLimitResult{Limit: policy.Requests}
+	r := b.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		res.RetryAfter = delay
+	} else {
+		res.Allowed = true
+	}
+
+	tokens := b.limiter.TokensAt(now)
+	if tokens > 0 {
+		res.Remaining = int(math.Floor(tokens))
+	}
+	missing := float64(policy.burst()) - tokens
+	res.Reset = time.Duration(missing * float64(policy.emissionInterval()))
+	return res
+}
+
+// rateLimitMiddleware limits requests under the policy chosen for each request
+// and reports the limit in the standard RateLimit-* response headers.
+// Authenticated callers are counted by user ID, everyone else by client IP.
+func rateLimitMiddleware(rl rateLimiter, policyFor func(*gin.Context) rateLimitPolicy, logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		policy := policyFor(c)
+		key := "ip:" + c.ClientIP()
+		if uid, ok := c.Get("user_id"); ok {
+			key = fmt.Sprintf("user:%v", uid)
+		}
+		res := rl.Allow(c.Request.Context(), key, policy)
+
+		c.Header("RateLimit-Limit", strconv.Itoa(res.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+		c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Requests, int(policy.Period.Seconds())))
+
+		if !res.Allowed {
+			logger.WithFields(logrus.Fields{"key": key, "policy": policy.Name}).Debug("rate limit exceeded")
+			rateLimitRejected(c, policy.Name)
+			c.Header("Retry-After", strconv.Itoa(max(ceilSeconds(res.RetryAfter), 1)))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ----------------------------------
+// Rate limit policies
+// ----------------------------------
+
+// defaultRateLimitPolicy applies wherever no other policy is named.
+const defaultRateLimitPolicy = "default"
+
+// rateLimitRule applies a named policy to the requests of a route whose path
+// and method match. An empty Methods list matches every method.
+type rateLimitRule struct {
+	Path    string   `yaml:"path"`
+	Methods []string `yaml:"methods"`
+	Policy  string   `yaml:"policy"`
+}
+
+// rateLimitPolicies holds every policy by name.
+type rateLimitPolicies map[string]rateLimitPolicy
+
+// validate checks the policies, and that every policy referenced by the
+// mappings exists.
+func (p rateLimitPolicies) validate(mappings []*routeMapping) error {
+	if _, ok := p[defaultRateLimitPolicy]; !ok {
+		return fmt.Errorf("a %q rate limit policy is required", defaultRateLimitPolicy)
+	}
+	for name, policy := range p {
+		if policy.Requests <= 0 || policy.Period <= 0 || policy.Burst < 0 {
+			return fmt.Errorf("rate limit policy %q: requests and period must be positive", name)
+		}
+	}
+	for _, m := range mappings {
+		if m.RateLimit != "" {
+			if _, ok := p[m.RateLimit]; !ok {
+				return fmt.Errorf("route %s: unknown rate limit policy %q", m.Prefix, m.RateLimit)
+			}
+		}
+		for _, r := range m.RateLimits {
+			if _, ok := p[r.Policy]; !ok {
+				return fmt.Errorf("route %s: rule %s: unknown rate limit policy %q", m.Prefix, r.Path, r.Policy)
+			}
+		}
+	}
+	return nil
+}
+
+// forRequest returns the policy for a request to the given route: the first
+// matching rule wins, then the route's own policy, then the default one.
+func (p rateLimitPolicies) forRequest(m *routeMapping, method, path string) rateLimitPolicy {
+	for _, r := range m.RateLimits {
+		if pathMatches(r.Path, path) && methodMatches(r.Methods, method) {
+			return p[r.Policy]
+		}
+	}
+	if m.RateLimit != "" {
+		return p[m.RateLimit]
+	}
+	return p[defaultRateLimitPolicy]
+}
+
+// pathMatches reports whether path matches pattern. A pattern ending in "/*"
+// matches the path before the wildcard and everything below it; any other
+// pattern must match exactly.
+func pathMatches(pattern, path string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return path == prefix || strings.HasPrefix(path, prefix+"/")
+	}
+	return path == pattern
+}
+
+// methodMatches reports whether method is listed, treating an empty list as
+// matching every method.
+func methodMatches(methods []string, method string) bool {
+	if len(methods) == 0 {
+		return true
+	}
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitSummary describes every policy for the health endpoint.
+func rateLimitSummary(p rateLimitPolicies) map[string]string {
+	summary := make(map[string]string, len(p))
+	for name, policy := range p {
+		summary[name] = policy.String()
+	}
+	return summary
+}
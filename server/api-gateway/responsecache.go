@@ -0,0 +1,706 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+// ----------------------------------
+// Response cache
+// ----------------------------------
+
+// cachePurgeChannel carries purges between services and gateway replicas.
+// A message is a space-separated list of surrogate keys.
+const cachePurgeChannel = "gateway:cache:purge"
+
+// cachedResponse is a response stored by the response cache.
+type cachedResponse struct {
+	Status   int           `json:"status"`
+	Header   http.Header   `json:"header"`
+	Body     []byte        `json:"body"`
+	Tags     []string      `json:"tags,omitempty"` // surrogate keys
+	StoredAt time.Time     `json:"stored_at"`
+	FreshFor time.Duration `json:"fresh_for"`
+	StaleFor time.Duration `json:"stale_for"` // stale-while-revalidate
+}
+
+func (r *cachedResponse) age(now time.Time) time.Duration {
+	return now.Sub(r.StoredAt)
+}
+
+func (r *cachedResponse) fresh(now time.Time) bool {
+	return r.age(now) < r.FreshFor
+}
+
+// expires returns when the response can no longer be served, even stale.
+func (r *cachedResponse) expires() time.Time {
+	return r.StoredAt.Add(r.FreshFor + r.StaleFor)
+}
+
+func (r *cachedResponse) hasTag(tags map[string]bool) bool {
+	for _, t := range r.Tags {
+		if tags[t] {
+			return true
+		}
+	}
+	return false
+}
+
+// responseCache is a shared cache of anonymous GET responses, honouring the
+// Cache-Control and Vary headers of the upstream. Responses are kept in Redis,
+// so that every gateway replica can serve them, with a small in-memory copy
+// of the most recent ones in front. While Redis is unreachable only the
+// in-memory copies are used.
+type responseCache struct {
+	client     *redis.Client
+	local      *localResponseCache
+	validators *validatorCache
+	maxBody    int
+	timeout    time.Duration
+	logger     *logrus.Logger
+
+	// proxy serves the background requests that revalidate stale responses
+	proxy        gin.HandlerFunc
+	revalidating sync.Map // key -> struct{}
+
+	retryInterval time.Duration
+	degradedUntil atomic.Int64 // unix nanoseconds
+	degraded      atomic.Bool
+}
+
+func newResponseCache(client *redis.Client, localEntries, maxBody int, validators *validatorCache, logger *logrus.Logger) *responseCache {
+	return &responseCache{
+		client:        client,
+		local:         newLocalResponseCache(localEntries),
+		validators:    validators,
+		maxBody:       maxBody,
+		timeout:       100 * time.Millisecond,
+		retryInterval: 5 * time.Second,
+		logger:        logger,
+	}
+}
+
+// revalidateThrough sets the handler that stale responses are refetched
+// through, normally the gateway's proxy handler. It is called directly, so
+// that revalidations are neither rate limited nor hit by injected faults.
+func (rc *responseCache) revalidateThrough(h gin.HandlerFunc) {
+	rc.proxy = h
+}
+
+func (rc *responseCache) available() bool {
+	return time.Now().UnixNano() >= rc.degradedUntil.Load()
+}
+
+// redisFailed switches to the in-memory cache for retryInterval.
+func (rc *responseCache) redisFailed(err error) {
+	rc.degradedUntil.Store(time.Now().Add(rc.retryInterval).UnixNano())
+	if !rc.degraded.Swap(true) {
+		rc.logger.WithError(err).Warn("Redis response cache unavailable; using in-memory cache only")
+	}
+}
+
+func (rc *responseCache) redisSucceeded() {
+	if rc.degraded.Swap(false) {
+		rc.logger.Info("Redis response cache recovered")
+	}
+}
+
+// Backend reports where responses are currently cached.
+func (rc *responseCache) Backend() string {
+	if rc.degraded.Load() {
+		return "local"
+	}
+	return "redis"
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func entryKey(key string) string          { return "gwcache:entry:" + hashKey(key) }
+func varyKey(base string) string          { return "gwcache:vary:" + hashKey(base) }
+func tagKey(tag string) string            { return "gwcache:tag:" + tag }
+func revalidateLockKey(key string) string { return "gwcache:lock:" + hashKey(key) }
+
+// variantKey extends base with the request headers the response varies on.
+func variantKey(base string, vary []string, h http.Header) string {
+	var b strings.Builder
+	b.WriteString(base)
+	for _, name := range vary {
+		b.WriteString("\x00" + name + "=" + strings.Join(h.Values(name), ","))
+	}
+	return b.String()
+}
+
+// lookup returns the response stored for the request, with the key it was
+// found under.
+func (rc *responseCache) lookup(ctx context.Context, base string, h http.Header) (*cachedResponse, string, bool) {
+	vary, ok := rc.local.vary(base)
+	if !ok && rc.available() {
+		ctx, cancel := context.WithTimeout(ctx, rc.timeout)
+		defer cancel()
+		data, err := rc.client.Get(ctx, varyKey(base)).Bytes()
+		switch {
+		case err == redis.Nil:
+			rc.redisSucceeded()
+			return nil, "", false
+		case err != nil:
+			rc.redisFailed(err)
+			return nil, "", false
+		}
+		if json.Unmarshal(data, &vary) != nil {
+			return nil, "", false
+		}
+		ok = true
+	}
+	if !ok {
+		return nil, "", false
+	}
+
+	key := variantKey(base, vary, h)
+	if resp, ok := rc.local.get(key); ok {
+		return resp, key, true
+	}
+	if !rc.available() {
+		return nil, "", false
+	}
+	ctx, cancel := context.WithTimeout(ctx, rc.timeout)
+	defer cancel()
+	data, err := rc.client.Get(ctx, entryKey(key)).Bytes()
+	switch {
+	case err == redis.Nil:
+		rc.redisSucceeded()
+		return nil, "", false
+	case err != nil:
+		rc.redisFailed(err)
+		return nil, "", false
+	}
+	rc.redisSucceeded()
+	var resp cachedResponse
+	if json.Unmarshal(data, &resp) != nil || !time.Now().Before(resp.expires()) {
+		return nil, "", false
+	}
+	rc.local.put(base, vary, key, &resp)
+	return &resp, key, true
+}
+
+// store keeps resp under the key of the request and the headers it varies on.
+func (rc *responseCache) store(ctx context.Context, base string, vary []string, h http.Header, resp *cachedResponse) {
+	key := variantKey(base, vary, h)
+	rc.local.put(base, vary, key, resp)
+	if !rc.available() {
+		return
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	varyData, _ := json.Marshal(vary)
+	ttl := resp.FreshFor + resp.StaleFor
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rc.timeout)
+	defer cancel()
+	pipe := rc.client.TxPipeline()
+	pipe.Set(ctx, entryKey(key), data, ttl)
+	pipe.Set(ctx, varyKey(base), varyData, ttl)
+	for _, tag := range resp.Tags {
+		pipe.SAdd(ctx, tagKey(tag), entryKey(key))
+		// A tag set lives as long as its longest-lived entry
+		pipe.ExpireNX(ctx, tagKey(tag), ttl)
+		pipe.ExpireGT(ctx, tagKey(tag), ttl)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		rc.redisFailed(err)
+		return
+	}
+	rc.redisSucceeded()
+}
+
+// purgeScript deletes the responses tagged with any of the tag sets in KEYS,
+// and the sets, in a single round trip. Members are deleted in batches, to
+// stay within the number of arguments Lua unpacks.
+var purgeScript = redis.NewScript(`
+for _, tag in ipairs(KEYS) do
+  local keys = redis.call('SMEMBERS', tag)
+  for i = 1, #keys, 500 do
+    redis.call('DEL', unpack(keys, i, math.min(i + 499, #keys)))
+  end
+  redis.call('DEL', tag)
+end
+return 0
+`)
+
+// Purge drops every response tagged with one of tags here and from Redis,
+// then has the other replicas drop their in-memory copies through
+// cachePurgeChannel.
+func (rc *responseCache) Purge(ctx context.Context, tags []string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	rc.purgeLocal(tags)
+	keys := make([]string, len(tags))
+	for i, tag := range tags {
+		keys[i] = tagKey(tag)
+	}
+	ctx, cancel := context.WithTimeout(ctx, rc.timeout)
+	defer cancel()
+	if err := purgeScript.Run(ctx, rc.client, keys).Err(); err != nil {
+		return err
+	}
+	return rc.client.Publish(ctx, cachePurgeChannel, strings.Join(tags, " ")).Err()
+}
+
+// purgeLocal drops the in-memory responses and ETags tagged with one of tags.
+func (rc *responseCache) purgeLocal(tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+	set := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		set[t] = true
+	}
+	rc.local.purge(set)
+	rc.validators.purge(set)
+	rc.logger.WithField("tags", tags).Debug("Cached responses purged")
+}
+
+// subscribe applies the purges announced by services and replicas, which
+// have already deleted the responses from Redis. Redis pub/sub resubscribes
+// by itself after a lost connection.
+func (rc *responseCache) subscribe(ctx context.Context) {
+	sub := rc.client.Subscribe(ctx, cachePurgeChannel)
+	go func() {
+		for msg := range sub.Channel() {
+			rc.purgeLocal(strings.Fields(msg.Payload))
+		}
+	}()
+}
+
+// beginRevalidation claims the revalidation of key for this replica, and
+// through a short-lived Redis lock for all of them. done must be called once
+// it finishes.
+func (rc *responseCache) beginRevalidation(ctx context.Context, key string, lease time.Duration) (done func(), ok bool) {
+	if _, busy := rc.revalidating.LoadOrStore(key, struct{}{}); busy {
+		return nil, false
+	}
+	done = func() { rc.revalidating.Delete(key) }
+	if !rc.available() {
+		return done, true
+	}
+	ctx, cancel := context.WithTimeout(ctx, rc.timeout)
+	defer cancel()
+	acquired, err := rc.client.SetNX(ctx, revalidateLockKey(key), 1, lease).Result()
+	if err != nil {
+		// Revalidating on every replica is better than not at all
+		rc.redisFailed(err)
+		return done, true
+	}
+	if !acquired {
+		done()
+		return nil, false
+	}
+	return done, true
+}
+
+// revalidate refetches the response of the request in c in the background,
+// without the caller's conditional headers, and stores it under base if it
+// may be cached. Error responses leave the stale copy in place.
+func (rc *responseCache) revalidate(c *gin.Context, base, key string, timeout time.Duration) {
+	if rc.proxy == nil {
+		return
+	}
+	done, ok := rc.beginRevalidation(c.Request.Context(), key, timeout)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), timeout)
+	bg := c.Copy()
+	bg.Request = c.Request.Clone(ctx)
+	for _, h := range []string{"If-None-Match", "If-Modified-Since", "Accept-Encoding", "Cache-Control"} {
+		bg.Request.Header.Del(h)
+	}
+	w := &revalidationRecorder{header: make(http.Header), status: http.StatusOK, limit: rc.maxBody}
+	bg.Writer = w
+	go func() {
+		defer cancel()
+		defer done()
+		rc.proxy(bg)
+		if !w.overflowed {
+			rc.keep(bg, base, w.status, w.header, w.buf)
+		}
+	}()
+}
+
+// keep stores the response to the request in c under base if it is a 200
+// that a shared cache may store.
+func (rc *responseCache) keep(c *gin.Context, base string, status int, h http.Header, body []byte) {
+	if status != http.StatusOK {
+		return
+	}
+	header := storedHeader(h)
+	fresh, stale, ok := sharedCacheLifetime(header)
+	if !ok {
+		return
+	}
+	vary, ok := cacheVary(header)
+	if !ok {
+		return
+	}
+	rc.store(c.Request.Context(), base, vary, c.Request.Header, &cachedResponse{
+		Status:   status,
+		Header:   header,
+		Body:     body,
+		Tags:     c.GetStringSlice("surrogate_keys"),
+		StoredAt: time.Now(),
+		FreshFor: fresh,
+		StaleFor: stale,
+	})
+}
+
+// responseCacheMiddleware serves anonymous GET requests from the response
+// cache. Fresh responses are served as they are. Responses within their
+// stale-while-revalidate window are served too, while a background request
+// fetches a fresh copy. Everything else goes to the upstream, and its response
+// is stored if its Cache-Control allows a shared cache to.
+func responseCacheMiddleware(rc *responseCache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet || streamKindOf(c.Request) != streamNone || !isAnonymous(c) {
+			c.Next()
+			return
+		}
+
+		// The version is part of the key, so that canary responses are only
+		// served to the callers routed to the canary
+		mapping := routeFromContext(c)
+		base := c.Request.URL.RequestURI() + "\x00" + mapping.selectVersion(c)
+
+		if !requestBypassesCache(c.Request.Header) {
+			if resp, key, ok := rc.lookup(c.Request.Context(), base, c.Request.Header); ok {
+				now := time.Now()
+				if resp.fresh(now) {
+					serveCached(c, resp, "HIT", now)
+					return
+				}
+				if now.Before(resp.expires()) {
+					rc.revalidate(c, base, key, mapping.timeout())
+					serveCached(c, resp, "STALE", now)
+					return
+				}
+			}
+		}
+
+		c.Header("X-Cache", "MISS")
+		w := &cacheRecorder{ResponseWriter: c.Writer, limit: rc.maxBody}
+		c.Writer = w
+		defer func() { c.Writer = w.ResponseWriter }()
+		c.Next()
+
+		if !w.overflowed {
+			rc.keep(c, base, w.Status(), w.Header(), w.buf)
+		}
+	}
+}
+
+// isAnonymous reports whether the request carries no credentials, so that
+// its response cannot be personal to the caller.
+func isAnonymous(c *gin.Context) bool {
+	_, identified := c.Get("user_id")
+	return !identified && c.GetHeader("Authorization") == ""
+}
+
+// requestBypassesCache reports whether the client asked for a response from
+// the upstream with Cache-Control: no-cache, no-store or max-age=0.
+func requestBypassesCache(h http.Header) bool {
+	cc := parseCacheControl(h)
+	_, noCache := cc["no-cache"]
+	_, noStore := cc["no-store"]
+	return noCache || noStore || cc["max-age"] == "0"
+}
+
+// serveCached writes a stored response with its current Age. The surrogate
+// keys go into the context like those of a proxied response.
+func serveCached(c *gin.Context, resp *cachedResponse, status string, now time.Time) {
+	for k, vs := range resp.Header {
+		c.Writer.Header()[k] = append([]string(nil), vs...)
+	}
+	c.Header("Age", strconv.Itoa(int(resp.age(now).Seconds())))
+	c.Header("X-Cache", status)
+	c.Set("surrogate_keys", resp.Tags)
+	c.Status(resp.Status)
+	c.Writer.Write(resp.Body)
+	c.Abort()
+}
+
+// unstoredHeaders are response headers that describe a single transfer
+// rather than the response.
+var unstoredHeaders = []string{"Connection", "Keep-Alive", "Transfer-Encoding", "Trailer", "Upgrade", "Proxy-Connection", "Age", "Date", "X-Cache", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy"}
+
+func storedHeader(h http.Header) http.Header {
+	stored := h.Clone()
+	for _, k := range unstoredHeaders {
+		stored.Del(k)
+	}
+	return stored
+}
+
+// sharedCacheLifetime returns how long a shared cache may serve the response
+// fresh and then stale while revalidating it. ok is false when the response
+// must not be stored: it is private, uncacheable, sets cookies or has no
+// explicit freshness.
+func sharedCacheLifetime(h http.Header) (fresh, stale time.Duration, ok bool) {
+	if h.Get("Set-Cookie") != "" {
+		return 0, 0, false
+	}
+	cc := parseCacheControl(h)
+	for _, d := range []string{"no-store", "no-cache", "private"} {
+		if _, set := cc[d]; set {
+			return 0, 0, false
+		}
+	}
+	seconds := func(name string) (time.Duration, bool) {
+		v, set := cc[name]
+		if !set {
+			return 0, false
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, false
+		}
+		return time.Duration(n) * time.Second, true
+	}
+	fresh, ok = seconds("s-maxage")
+	if !ok {
+		fresh, ok = seconds("max-age")
+	}
+	if !ok || fresh <= 0 {
+		return 0, 0, false
+	}
+	stale, _ = seconds("stale-while-revalidate")
+	return fresh, stale, true
+}
+
+// cacheVary returns the request headers the response varies on, sorted. ok
+// is false for responses that vary on everything or on the caller's
+// credentials.
+func cacheVary(h http.Header) (vary []string, ok bool) {
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			switch name {
+			case "":
+			case "*", "Authorization", "Cookie":
+				return nil, false
+			default:
+				vary = append(vary, name)
+			}
+		}
+	}
+	sort.Strings(vary)
+	return vary, true
+}
+
+// parseCacheControl returns the directives of the Cache-Control header, by
+// lowercase name, with quotes removed from their values.
+func parseCacheControl(h http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(d), "=")
+			if name != "" {
+				directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+			}
+		}
+	}
+	return directives
+}
+
+// cacheRecorder passes a response through while keeping a copy of its body
+// of up to limit bytes.
+type cacheRecorder struct {
+	gin.ResponseWriter
+	limit      int
+	buf        []byte
+	overflowed bool
+}
+
+func (w *cacheRecorder) Write(p []byte) (int, error) {
+	if !w.overflowed {
+		if len(w.buf)+len(p) > w.limit {
+			w.overflowed = true
+			w.buf = nil
+		} else {
+			w.buf = append(w.buf, p...)
+		}
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *cacheRecorder) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// revalidationRecorder receives the response of a background revalidation,
+// keeping its status, headers and up to limit bytes of its body. There is no
+// client connection behind it.
+type revalidationRecorder struct {
+	header     http.Header
+	status     int
+	size       int
+	written    bool
+	limit      int
+	buf        []byte
+	overflowed bool
+}
+
+func (w *revalidationRecorder) Header() http.Header { return w.header }
+
+func (w *revalidationRecorder) WriteHeader(status int) {
+	if !w.written {
+		w.status = status
+	}
+}
+
+func (w *revalidationRecorder) WriteHeaderNow() { w.written = true }
+
+func (w *revalidationRecorder) Write(p []byte) (int, error) {
+	w.written = true
+	w.size += len(p)
+	if !w.overflowed {
+		if len(w.buf)+len(p) > w.limit {
+			w.overflowed = true
+			w.buf = nil
+		} else {
+			w.buf = append(w.buf, p...)
+		}
+	}
+	return len(p), nil
+}
+
+func (w *revalidationRecorder) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *revalidationRecorder) Status() int   { return w.status }
+func (w *revalidationRecorder) Size() int     { return w.size }
+func (w *revalidationRecorder) Written() bool { return w.written }
+func (w *revalidationRecorder) Flush()        {}
+
+func (w *revalidationRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("revalidation has no connection")
+}
+
+func (w *revalidationRecorder) CloseNotify() <-chan bool { return nil }
+func (w *revalidationRecorder) Pusher() http.Pusher      { return nil }
+
+// localResponseCache keeps up to maxEntries responses in memory, each until
+// it can no longer be served, along with the Vary headers of their keys.
+type localResponseCache struct {
+	maxEntries int
+
+	mu        sync.Mutex
+	responses map[string]*cachedResponse
+	varies    map[string]localVary // base key -> vary
+}
+
+type localVary struct {
+	headers []string
+	expires time.Time
+}
+
+func newLocalResponseCache(maxEntries int) *localResponseCache {
+	return &localResponseCache{
+		maxEntries: maxEntries,
+		responses:  make(map[string]*cachedResponse),
+		varies:     make(map[string]localVary),
+	}
+}
+
+func (l *localResponseCache) vary(base string) ([]string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	v, ok := l.varies[base]
+	if !ok || !time.Now().Before(v.expires) {
+		delete(l.varies, base)
+		return nil, false
+	}
+	return v.headers, true
+}
+
+func (l *localResponseCache) get(key string) (*cachedResponse, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	resp, ok := l.responses[key]
+	if !ok || !time.Now().Before(resp.expires()) {
+		delete(l.responses, key)
+		return nil, false
+	}
+	return resp, true
+}
+
+func (l *localResponseCache) put(base string, vary []string, key string, resp *cachedResponse) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.responses[key]; !ok && len(l.responses) >= l.maxEntries {
+		l.evictLocked()
+	}
+	l.responses[key] = resp
+	l.varies[base] = localVary{headers: vary, expires: resp.expires()}
+}
+
+// evictLocked drops the responses that have expired or, if none has, an
+// arbitrary one.
+func (l *localResponseCache) evictLocked() {
+	now := time.Now()
+	for key, resp := range l.responses {
+		if !now.Before(resp.expires()) {
+			delete(l.responses, key)
+		}
+	}
+	for base, v := range l.varies {
+		if !now.Before(v.expires) {
+			delete(l.varies, base)
+		}
+	}
+	for key := range l.responses {
+		if len(l.responses) < l.maxEntries {
+			break
+		}
+		delete(l.responses, key)
+	}
+}
+
+func (l *localResponseCache) purge(tags map[string]bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, resp := range l.responses {
+		if resp.hasTag(tags) {
+			delete(l.responses, key)
+		}
+	}
+}
+
+// Len returns the number of responses kept in memory.
+func (l *localResponseCache) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.responses)
+}
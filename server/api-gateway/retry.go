@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ----------------------------------
+// Retries
+// ----------------------------------
+
+type retryConfig struct {
+	// MaxAttempts is the total number of attempts per request, including the
+	// first one. A value of 1 disables retries.
+	MaxAttempts int
+	// BaseBackoff is the delay before the first retry; it doubles for every
+	// further retry up to MaxBackoff. The actual delay is jittered.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// BudgetRatio is the number of retries earned by each request, so 0.2
+	// allows retries to add at most 20% on top of the regular traffic.
+	BudgetRatio float64
+	// BudgetMinPerSecond is a floor so that low-traffic routes can still retry.
+	BudgetMinPerSecond float64
+}
+
+func (cfg retryConfig) validate() error {
+	switch {
+	case cfg.BaseBackoff <= 0:
+		return errors.New("RETRY_BASE_BACKOFF must be positive")
+	case cfg.MaxBackoff <= 0:
+		return errors.New("RETRY_MAX_BACKOFF must be positive")
+	}
+	return nil
+}
+
+// isIdempotent reports whether a request may safely be sent more than once.
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+// backoff returns the jittered delay before the given retry (1 for the first).
+func (cfg retryConfig) backoff(retry int) time.Duration {
+	d := cfg.BaseBackoff << (retry - 1)
+	if d <= 0 || d > cfg.MaxBackoff {
+		d = cfg.MaxBackoff
+	}
+	// Equal jitter: half fixed, half random, so that retries from many
+	// clients do not line up while still backing off.
+	half := d / 2
+	return half + rand.N(half+1)
+}
+
+// retryBudget caps retries as a fraction of the requests seen, so that a
+// failing upstream is not hit with a multiple of its normal traffic.
+type retryBudget struct {
+	ratio     float64
+	minPerSec float64
+	max       float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newRetryBudget(cfg retryConfig) *retryBudget {
+	// Allow roughly ten seconds' worth of the floor rate to accumulate.
+	max := cfg.BudgetMinPerSecond * 10
+	if max < 1 {
+		max = 1
+	}
+	return &retryBudget{
+		ratio:     cfg.BudgetRatio,
+		minPerSec: cfg.BudgetMinPerSecond,
+		max:       max,
+		tokens:    max,
+		last:      time.Now(),
+	}
+}
+
+// deposit credits the budget for one incoming request.
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.add(b.ratio)
+}
+
+// withdraw takes one retry from the budget, reporting false if none is left.
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.add(now.Sub(b.last).Seconds() * b.minPerSec)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// add credits n tokens up to the cap. The caller must hold b.mu.
+func (b *retryBudget) add(n float64) {
+	b.tokens += n
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+}
+
+// trackedBody wraps a request body so that the proxy can tell whether any of
+// it has been sent upstream. A body that has been partly read cannot be
+// replayed, so such a request is never retried. Close is a no-op because the
+// transport closes the body after every attempt, including failed ones; the
+// server closes the underlying body once the handler returns.
+type trackedBody struct {
+	rc   io.ReadCloser
+	read atomic.Bool
+}
+
+func newTrackedBody(rc io.ReadCloser) *trackedBody {
+	return &trackedBody{rc: rc}
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	b.read.Store(true)
+	return b.rc.Read(p)
+}
+
+func (b *trackedBody) Close() error {
+	return nil
+}
+
+// consumed reports whether the upstream has started reading the body.
+func (b *trackedBody) consumed() bool {
+	return b != nil && b.read.Load()
+}
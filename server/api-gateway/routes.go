@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// ----------------------------------
+// Route configuration
+// ----------------------------------
+
+const defaultRouteTimeout = 15 * time.Second
+
+// routeMapping forwards every request below Prefix to ServiceName, replacing
+// Prefix with RewritePrefix.
+type routeMapping struct {
+	Prefix        string          `yaml:"prefix"`
+	ServiceName   string          `yaml:"service"`
+	RewritePrefix string          `yaml:"rewrite"`
+	Auth          authLevel       `yaml:"auth"`        // required when empty
+	AuthRules     []authRule      `yaml:"auth_rules"`  // auth levels for specific paths and methods
+	Methods       []string        `yaml:"methods"`     // allowed methods; every method when empty
+	Timeout       time.Duration   `yaml:"timeout"`     // whole request, including retries
+	RateLimit     string          `yaml:"rate_limit"`  // policy for the whole route; "default" when empty
+	RateLimits    []rateLimitRule `yaml:"rate_limits"` // policies for specific paths and methods
+	Transport     transportConfig `yaml:"transport"`   // connection pool and timeouts towards each instance
+	HealthPath    string          `yaml:"health_path"` // health endpoint of the service; rewrite + "/health" when empty
+	DocsPath      string          `yaml:"docs_path"`   // OpenAPI document of the service; rewrite + "/openapi.json" when empty
+	Request       requestRules    `yaml:"request"`     // body size, content types and schema validation
+	Versions      []versionRule   `yaml:"versions"`    // routing to instances by version; the first match wins
+}
+
+// timeout returns the route's request timeout, or the default one.
+func (m *routeMapping) timeout() time.Duration {
+	if m.Timeout > 0 {
+		return m.Timeout
+	}
+	return defaultRouteTimeout
+}
+
+// matches reports whether path is the route's prefix or lies below it.
+func (m *routeMapping) matches(path string) bool {
+	return path == m.Prefix || strings.HasPrefix(path, m.Prefix+"/")
+}
+
+// gatewayConfig is the declarative configuration file of the gateway.
+type gatewayConfig struct {
+	RateLimitPolicies rateLimitPolicies `yaml:"rate_limit_policies"`
+	Routes            []*routeMapping   `yaml:"routes"`
+}
+
+func parseGatewayConfig(data []byte) (*gatewayConfig, error) {
+	var cfg gatewayConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, err
+	}
+	for name, p := range cfg.RateLimitPolicies {
+		p.Name = name
+		cfg.RateLimitPolicies[name] = p
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func (cfg *gatewayConfig) validate() error {
+	if len(cfg.Routes) == 0 {
+		return errors.New("no routes configured")
+	}
+
+	prefixes := make(map[string]bool)
+	for i, r := range cfg.Routes {
+		switch {
+		case !strings.HasPrefix(r.Prefix, "/") || (len(r.Prefix) > 1 && strings.HasSuffix(r.Prefix, "/")):
+			return fmt.Errorf("route %d: prefix %q must start with and not end in /", i, r.Prefix)
+		case prefixes[r.Prefix]:
+			return fmt.Errorf("route %s: duplicate prefix", r.Prefix)
+		case r.ServiceName == "":
+			return fmt.Errorf("route %s: service is required", r.Prefix)
+		case r.RewritePrefix != "" && !strings.HasPrefix(r.RewritePrefix, "/"):
+			return fmt.Errorf("route %s: rewrite %q must start with /", r.Prefix, r.RewritePrefix)
+		case r.HealthPath != "" && !strings.HasPrefix(r.HealthPath, "/"):
+			return fmt.Errorf("route %s: health_path %q must start with /", r.Prefix, r.HealthPath)
+		case r.DocsPath != "" && !strings.HasPrefix(r.DocsPath, "/"):
+			return fmt.Errorf("route %s: docs_path %q must start with /", r.Prefix, r.DocsPath)
+		case r.Timeout < 0:
+			return fmt.Errorf("route %s: timeout must not be negative", r.Prefix)
+		}
+		prefixes[r.Prefix] = true
+
+		for j, m := range r.Methods {
+			if !isHTTPMethod(m) {
+				return fmt.Errorf("route %s: unknown method %q", r.Prefix, m)
+			}
+			r.Methods[j] = strings.ToUpper(m)
+		}
+		for _, rule := range r.RateLimits {
+			if !r.matches(strings.TrimSuffix(rule.Path, "/*")) {
+				return fmt.Errorf("route %s: rate limit path %q is outside the route", r.Prefix, rule.Path)
+			}
+		}
+		if err := r.validateAuthRules(); err != nil {
+			return err
+		}
+		if err := r.Transport.validate(); err != nil {
+			return fmt.Errorf("route %s: %w", r.Prefix, err)
+		}
+		if err := r.Request.validate(); err != nil {
+			return fmt.Errorf("route %s: %w", r.Prefix, err)
+		}
+		if err := r.validateVersions(); err != nil {
+			return err
+		}
+	}
+	return cfg.RateLimitPolicies.validate(cfg.Routes)
+}
+
+func isHTTPMethod(m string) bool {
+	switch strings.ToUpper(m) {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions, http.MethodConnect, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// routeTable is an immutable snapshot of the configuration. A reload swaps in
+// a new table; requests already in flight keep the one they started with.
+type routeTable struct {
+	routes   []*routeMapping // longest prefix first
+	policies rateLimitPolicies
+	loadedAt time.Time
+}
+
+func newRouteTable(cfg *gatewayConfig) *routeTable {
+	routes := make([]*routeMapping, len(cfg.Routes))
+	copy(routes, cfg.Routes)
+	sort.SliceStable(routes, func(i, j int) bool { return len(routes[i].Prefix) > len(routes[j].Prefix) })
+	return &routeTable{routes: routes, policies: cfg.RateLimitPolicies, loadedAt: time.Now()}
+}
+
+// match returns the route with the longest prefix matching path, or nil.
+func (t *routeTable) match(path string) *routeMapping {
+	for _, r := range t.routes {
+		if r.matches(path) {
+			return r
+		}
+	}
+	return nil
+}
+
+// services returns the distinct services the routes forward to.
+func (t *routeTable) services() []string {
+	seen := make(map[string]bool)
+	var services []string
+	for _, r := range t.routes {
+		if !seen[r.ServiceName] {
+			seen[r.ServiceName] = true
+			services = append(services, r.ServiceName)
+		}
+	}
+	sort.Strings(services)
+	return services
+}
+
+// healthPath returns the health endpoint of service: the first health_path
+// set on a route to it, longest prefix first, or else the rewrite prefix of
+// the first such route followed by /health.
+func (t *routeTable) healthPath(service string) string {
+	return t.servicePath(service, func(r *routeMapping) string { return r.HealthPath }, "/health")
+}
+
+// docsPath returns the OpenAPI document endpoint of service, found the same
+// way as its health endpoint.
+func (t *routeTable) docsPath(service string) string {
+	return t.servicePath(service, func(r *routeMapping) string { return r.DocsPath }, "/openapi.json")
+}
+
+func (t *routeTable) servicePath(service string, configured func(*routeMapping) string, suffix string) string {
+	path := suffix
+	found := false
+	for _, r := range t.routes {
+		if r.ServiceName != service {
+			continue
+		}
+		if p := configured(r); p != "" {
+			return p
+		}
+		if !found {
+			path = strings.TrimSuffix(r.RewritePrefix, "/") + suffix
+			found = true
+		}
+	}
+	return path
+}
+
+// routeStore holds the current route table and reloads it from the
+// configuration file on SIGHUP or when the file changes.
+type routeStore struct {
+	path   string
+	logger *logrus.Logger
+
+	current atomic.Pointer[routeTable]
+
+	mu      sync.Mutex // serialises reloads
+	modTime time.Time
+	size    int64
+}
+
+// newRouteStore loads the configuration file. Unlike a reload, a broken file
+// at startup is an error.
+func newRouteStore(path string, logger *logrus.Logger) (*routeStore, error) {
+	s := &routeStore{path: path, logger: logger}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *routeStore) Load() *routeTable {
+	return s.current.Load()
+}
+
+func (s *routeStore) reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	// Remember the file even if it is rejected, so that it is not parsed
+	// again until it changes.
+	s.modTime, s.size = info.ModTime(), info.Size()
+
+	cfg, err := parseGatewayConfig(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.path, err)
+	}
+
+	s.current.Store(newRouteTable(cfg))
+	s.logger.WithFields(logrus.Fields{"file": s.path, "routes": len(cfg.Routes)}).Info("Route configuration loaded")
+	return nil
+}
+
+// changed reports whether the file differs from the one last loaded.
+func (s *routeStore) changed() bool {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !info.ModTime().Equal(s.modTime) || info.Size() != s.size
+}
+
+// watch reloads the configuration on SIGHUP and whenever the file changes. A
+// file that fails validation is logged and ignored; the previous routes stay
+// in effect.
+func (s *routeStore) watch(pollInterval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(pollInterval)
+
+	go func() {
+		for {
+			select {
+			case <-hup:
+			case <-ticker.C:
+				if !s.changed() {
+					continue
+				}
+			}
+			if err := s.reload(); err != nil {
+				s.logger.WithError(err).Error("Route configuration rejected; keeping previous routes")
+			}
+		}
+	}()
+}
+
+// routeMiddleware resolves the route of every request against the current
+// table and stores both in the context for the handlers that follow.
+func routeMiddleware(store *routeStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		table := store.Load()
+		c.Set("route_table", table)
+		if r := table.match(c.Request.URL.Path); r != nil {
+			c.Set("route", r)
+		}
+		c.Next()
+	}
+}
+
+func routeFromContext(c *gin.Context) *routeMapping {
+	r, _ := c.Get("route")
+	m, _ := r.(*routeMapping)
+	return m
+}
+
+func routeTableFromContext(c *gin.Context) *routeTable {
+	return c.MustGet("route_table").(*routeTable)
+}
+
+// routeGuard rejects requests that match no route or use a method the route
+// does not allow.
+func routeGuard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := routeFromContext(c)
+		if route == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+			c.Abort()
+			return
+		}
+		if !methodMatches(route.Methods, c.Request.Method) {
+			c.Header("Allow", strings.Join(route.Methods, ", "))
+			c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "Method not allowed"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ----------------------------------
+// Streaming requests (SSE and WebSocket)
+// ----------------------------------
+
+// Streams are proxied like any other request, except that they have no
+// request timeout, responses are flushed as soon as the upstream writes them,
+// and every caller may only hold a limited number of them open at once.
+
+type streamKind string
+
+const (
+	streamNone      streamKind = ""
+	streamSSE       streamKind = "sse"
+	streamWebSocket streamKind = "websocket"
+)
+
+// streamTokenParam is the query parameter that may carry the JWT of a stream
+// request. Browsers cannot set headers on EventSource and WebSocket
+// connections.
+const streamTokenParam = "access_token"
+
+// streamKindOf recognises WebSocket upgrades and Server-Sent Events requests.
+func streamKindOf(req *http.Request) streamKind {
+	if headerHasToken(req.Header, "Connection", "upgrade") && strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+		return streamWebSocket
+	}
+	if req.Method == http.MethodGet && headerHasToken(req.Header, "Accept", "text/event-stream") {
+		return streamSSE
+	}
+	return streamNone
+}
+
+// headerHasToken reports whether a comma-separated header contains token,
+// ignoring case and parameters such as ";q=0.9".
+func headerHasToken(h http.Header, key, token string) bool {
+	for _, v := range h.Values(key) {
+		for _, part := range strings.Split(v, ",") {
+			part, _, _ = strings.Cut(part, ";")
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// streamTokenMiddleware moves the token of a stream request from the query
+// string into the Authorization header, where jwtAuthMiddleware checks it.
+// The parameter is removed so that it is neither traced, logged nor
+// forwarded. A token in the header takes precedence.
+func streamTokenMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if streamKindOf(c.Request) == streamNone {
+			c.Next()
+			return
+		}
+		query := c.Request.URL.Query()
+		if token := query.Get(streamTokenParam); token != "" {
+			if c.GetHeader("Authorization") == "" {
+				c.Request.Header.Set("Authorization", "Bearer "+token)
+			}
+			query.Del(streamTokenParam)
+			c.Request.URL.RawQuery = query.Encode()
+		}
+		c.Next()
+	}
+}
+
+// streamLimiter caps the streams open at once per caller on this gateway
+// instance. Callers are identified by user ID, or by IP when anonymous.
+// Streams would hold up a shutdown indefinitely, so closeAll ends them all.
+type streamLimiter struct {
+	max int
+
+	mu     sync.Mutex
+	active map[string]int
+
+	closed    context.Context
+	closeFunc context.CancelFunc
+}
+
+func newStreamLimiter(max int) *streamLimiter {
+	closed, closeFunc := context.WithCancel(context.Background())
+	return &streamLimiter{max: max, active: make(map[string]int), closed: closed, closeFunc: closeFunc}
+}
+
+// closing reports whether closeAll has been called.
+func (l *streamLimiter) closing() bool {
+	return l.closed.Err() != nil
+}
+
+// closeAll cancels the requests of every open stream, which closes them on
+// both sides.
+func (l *streamLimiter) closeAll() {
+	l.closeFunc()
+}
+
+// acquire reserves a stream for the caller of c and ties the context of its
+// request to closeAll. It returns false when the caller is at the limit;
+// otherwise release must be called when the stream ends.
+func (l *streamLimiter) acquire(c *gin.Context) (release func(), ok bool) {
+	key := "ip:" + c.ClientIP()
+	if userID, exists := c.Get("user_id"); exists {
+		key = fmt.Sprintf("user:%v", userID)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.max > 0 && l.active[key] >= l.max {
+		return nil, false
+	}
+	l.active[key]++
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	stop := context.AfterFunc(l.closed, cancel)
+	c.Request = c.Request.WithContext(ctx)
+	return func() {
+		stop()
+		cancel()
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.active[key]--; l.active[key] <= 0 {
+			delete(l.active, key)
+		}
+	}, true
+}
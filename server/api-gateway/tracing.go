@@ -0,0 +1,20 @@
+package main
+
+import "github.com/gin-gonic/gin"
+
+// ----------------------------------
+// Tracing
+// ----------------------------------
+
+// spanName names a server span after the gateway endpoint or the proxied
+// route prefix, which keeps span names low-cardinality. It relies on
+// routeMiddleware having run.
+func spanName(c *gin.Context) string {
+	if path := c.FullPath(); path != "" {
+		return c.Request.Method + " " + path
+	}
+	if route := routeFromContext(c); route != nil {
+		return c.Request.Method + " " + route.Prefix
+	}
+	return c.Request.Method
+}
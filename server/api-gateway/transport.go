@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// ----------------------------------
+// Upstream transports
+// ----------------------------------
+
+const (
+	defaultDialTimeout           = 5 * time.Second
+	defaultTLSHandshakeTimeout   = 5 * time.Second
+	defaultIdleConnTimeout       = 90 * time.Second
+	defaultResponseHeaderTimeout = 10 * time.Second
+	defaultMaxIdleConnsPerHost   = 100
+)
+
+// transportConfig tunes the connections of a route to each instance of its
+// service. Zero fields take the defaults; MaxConnsPerHost is unlimited when
+// zero. It is comparable so that routes with the same settings share
+// transports.
+type transportConfig struct {
+	MaxIdleConnsPerHost   int           `yaml:"max_idle_conns_per_host"`
+	MaxConnsPerHost       int           `yaml:"max_conns_per_host"`
+	DialTimeout           time.Duration `yaml:"dial_timeout"`
+	TLSHandshakeTimeout   time.Duration `yaml:"tls_handshake_timeout"`
+	IdleConnTimeout       time.Duration `yaml:"idle_conn_timeout"`
+	ResponseHeaderTimeout time.Duration `yaml:"response_header_timeout"`
+}
+
+func (t transportConfig) validate() error {
+	switch {
+	case t.MaxIdleConnsPerHost < 0, t.MaxConnsPerHost < 0:
+		return fmt.Errorf("transport connection limits must not be negative")
+	case t.DialTimeout < 0, t.TLSHandshakeTimeout < 0, t.IdleConnTimeout < 0, t.ResponseHeaderTimeout < 0:
+		return fmt.Errorf("transport timeouts must not be negative")
+	}
+	return nil
+}
+
+// summary describes the effective settings for the admin API.
+func (t transportConfig) summary() map[string]interface{} {
+	maxConns := "unlimited"
+	if t.MaxConnsPerHost > 0 {
+		maxConns = fmt.Sprint(t.MaxConnsPerHost)
+	}
+	return map[string]interface{}{
+		"max_idle_conns_per_host": orDefault(t.MaxIdleConnsPerHost, defaultMaxIdleConnsPerHost),
+		"max_conns_per_host":      maxConns,
+		"dial_timeout":            orDefault(t.DialTimeout, defaultDialTimeout).String(),
+		"tls_handshake_timeout":   orDefault(t.TLSHandshakeTimeout, defaultTLSHandshakeTimeout).String(),
+		"idle_conn_timeout":       orDefault(t.IdleConnTimeout, defaultIdleConnTimeout).String(),
+		"response_header_timeout": orDefault(t.ResponseHeaderTimeout, defaultResponseHeaderTimeout).String(),
+	}
+}
+
+func (t transportConfig) newTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   orDefault(t.DialTimeout, defaultDialTimeout),
+		KeepAlive: 30 * time.Second,
+	}
+	maxIdle := orDefault(t.MaxIdleConnsPerHost, defaultMaxIdleConnsPerHost)
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          maxIdle,
+		MaxIdleConnsPerHost:   maxIdle,
+		MaxConnsPerHost:       t.MaxConnsPerHost,
+		IdleConnTimeout:       orDefault(t.IdleConnTimeout, defaultIdleConnTimeout),
+		TLSHandshakeTimeout:   orDefault(t.TLSHandshakeTimeout, defaultTLSHandshakeTimeout),
+		ResponseHeaderTimeout: orDefault(t.ResponseHeaderTimeout, defaultResponseHeaderTimeout),
+	}
+}
+
+func orDefault[T int | time.Duration](v, def T) T {
+	if v > 0 {
+		return v
+	}
+	return def
+}
+
+// proxyKey identifies one of an instance's proxies. Streams get their own,
+// as they flush immediately and wait for response headers indefinitely.
+type proxyKey struct {
+	transport transportConfig
+	stream    bool
+}
+
+type instanceProxy struct {
+	proxy     *httputil.ReverseProxy
+	traced    http.RoundTripper // transport, instrumented for tracing
+	transport *http.Transport
+}
+
+// proxy returns the instance's reverse proxy for the given transport
+// settings, building it on first use. Proxies live as long as the instance,
+// which Discovery replaces when its address changes.
+func (u *upstreamInstance) proxy(cfg transportConfig, stream bool, logger *logrus.Logger) (*httputil.ReverseProxy, error) {
+	p, err := u.instanceProxy(cfg, stream, logger)
+	if err != nil {
+		return nil, err
+	}
+	return p.proxy, nil
+}
+
+// roundTripper returns the pooled transport behind the instance's proxy for
+// the given transport settings, for requests the gateway makes itself.
+func (u *upstreamInstance) roundTripper(cfg transportConfig, logger *logrus.Logger) (http.RoundTripper, error) {
+	p, err := u.instanceProxy(cfg, false, logger)
+	if err != nil {
+		return nil, err
+	}
+	return p.traced, nil
+}
+
+func (u *upstreamInstance) instanceProxy(cfg transportConfig, stream bool, logger *logrus.Logger) (*instanceProxy, error) {
+	key := proxyKey{transport: cfg, stream: stream}
+
+	u.proxiesMu.Lock()
+	defer u.proxiesMu.Unlock()
+	if p, ok := u.proxies[key]; ok {
+		return p, nil
+	}
+
+	target, err := url.Parse(u.Address)
+	if err != nil {
+		return nil, err
+	}
+	transport := cfg.newTransport()
+	var flushInterval time.Duration
+	if stream {
+		// An event stream may not send its headers before its first event,
+		// and its events must reach the client as soon as they are written
+		transport.ResponseHeaderTimeout = 0
+		flushInterval = -1
+	}
+
+	// Each attempt gets a client span and carries its trace context
+	traced := otelhttp.NewTransport(transport)
+	p := &instanceProxy{
+		proxy:     newReverseProxy(target, traced, flushInterval, logger),
+		traced:    traced,
+		transport: transport,
+	}
+	if u.proxies == nil {
+		u.proxies = make(map[proxyKey]*instanceProxy)
+	}
+	u.proxies[key] = p
+	return p, nil
+}
+
+// closeIdleConnections releases the pooled connections of an instance that
+// is no longer routed to. Requests still in flight are not affected.
+func (u *upstreamInstance) closeIdleConnections() {
+	u.proxiesMu.Lock()
+	defer u.proxiesMu.Unlock()
+	for _, p := range u.proxies {
+		p.transport.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/mail"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ----------------------------------
+// Request validation
+// ----------------------------------
+
+const (
+	defaultMaxBodySize = 1 << 20
+	// maxValidationErrors caps the problems reported for one body.
+	maxValidationErrors = 10
+	// maxSchemaDepth bounds $ref resolution, in case a document has a cycle
+	// that a value happens to follow.
+	maxSchemaDepth = 32
+)
+
+// requestRules limit the bodies a route forwards.
+type requestRules struct {
+	MaxBodySize    int64    `yaml:"max_body_size" json:"max_body_size"`     // bytes; defaultMaxBodySize when zero
+	ContentTypes   []string `yaml:"content_types" json:"content_types"`     // media types accepted for bodies; any when empty
+	ValidateSchema bool     `yaml:"validate_schema" json:"validate_schema"` // check JSON bodies against the service's OpenAPI document
+}
+
+func (r *requestRules) validate() error {
+	if r.MaxBodySize < 0 {
+		return errors.New("request max_body_size must not be negative")
+	}
+	for i, ct := range r.ContentTypes {
+		mediaType, _, err := mime.ParseMediaType(ct)
+		if err != nil {
+			return fmt.Errorf("request content type %q: %w", ct, err)
+		}
+		r.ContentTypes[i] = mediaType
+	}
+	return nil
+}
+
+func (r requestRules) maxBodySize() int64 {
+	if r.MaxBodySize > 0 {
+		return r.MaxBodySize
+	}
+	return defaultMaxBodySize
+}
+
+// summary describes the effective rules for the admin API.
+func (r requestRules) summary() requestRules {
+	r.MaxBodySize = r.maxBodySize()
+	return r
+}
+
+// requestValidationMiddleware rejects requests whose body is larger than the
+// route allows or of a content type it does not accept. On routes that
+// validate schemas, a JSON body must also match the request body schema of
+// the operation in the service's OpenAPI document. Requests to operations
+// whose document has not been fetched are not schema checked.
+func requestValidationMiddleware(docs *apiDocs) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req := c.Request
+		if req.ContentLength == 0 {
+			c.Next()
+			return
+		}
+		route := routeFromContext(c)
+		rules := route.Request
+
+		limit := rules.maxBodySize()
+		if req.ContentLength > limit {
+			rejectRequest(c, http.StatusRequestEntityTooLarge, "Request body too large",
+				fmt.Sprintf("the limit is %d bytes", limit))
+			return
+		}
+
+		mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		if len(rules.ContentTypes) > 0 && (err != nil || !containsFold(rules.ContentTypes, mediaType)) {
+			rejectRequest(c, http.StatusUnsupportedMediaType, "Unsupported content type",
+				"accepted: "+strings.Join(rules.ContentTypes, ", "))
+			return
+		}
+
+		var op, schemas map[string]any
+		validate := false
+		if rules.ValidateSchema && isJSON(req.Header) {
+			op, schemas, validate = docs.operation(route.ServiceName, req.Method, upstreamPath(route, req.URL.Path))
+		}
+		// A body of unknown length is read up front, so that it is cut off at
+		// the limit with a 413 rather than in the middle of proxying
+		if !validate && req.ContentLength > 0 {
+			c.Next()
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(req.Body, limit+1))
+		if err != nil {
+			rejectRequest(c, http.StatusBadRequest, "Failed to read request body", err.Error())
+			return
+		}
+		if int64(len(body)) > limit {
+			rejectRequest(c, http.StatusRequestEntityTooLarge, "Request body too large",
+				fmt.Sprintf("the limit is %d bytes", limit))
+			return
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+		req.TransferEncoding = nil
+
+		if validate {
+			if problems := validateRequestBody(op, schemas, body); len(problems) > 0 {
+				rejectRequest(c, http.StatusBadRequest, "Request body does not match the API schema", problems...)
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// rejectRequest answers with the gateway's error body, listing what is
+// wrong with the request in details when there is anything to list.
+func rejectRequest(c *gin.Context, status int, message string, details ...string) {
+	body := gin.H{"error": message}
+	if len(details) > 0 {
+		body["details"] = details
+	}
+	c.AbortWithStatusJSON(status, body)
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// upstreamPath is the path a request to route is forwarded to.
+func upstreamPath(route *routeMapping, path string) string {
+	p := route.RewritePrefix + strings.TrimPrefix(path, route.Prefix)
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return p
+}
+
+// validateRequestBody checks body against the JSON request body schema of
+// op and returns what does not match.
+func validateRequestBody(op, schemas map[string]any, body []byte) []string {
+	requestBody, _ := op["requestBody"].(map[string]any)
+	if requestBody == nil {
+		return nil
+	}
+	content, _ := requestBody["content"].(map[string]any)
+	media, _ := content["application/json"].(map[string]any)
+	schema, _ := media["schema"].(map[string]any)
+	if schema == nil {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return []string{"malformed JSON: " + err.Error()}
+	}
+	if dec.More() {
+		return []string{"malformed JSON: unexpected data after the top-level value"}
+	}
+
+	v := &schemaValidator{schemas: schemas}
+	v.check(schema, value, "body", 0)
+	return v.problems
+}
+
+// schemaValidator checks values against the subset of JSON Schema that the
+// services' OpenAPI documents use: $ref, allOf, type, properties, required,
+// items, additionalProperties, enum, the length, item count and value bounds,
+// and the email, uuid and date-time formats. A null counts as an absent
+// value, as it does when the services decode it.
+type schemaValidator struct {
+	schemas  map[string]any
+	problems []string
+}
+
+// report adds a problem, once: a schema and the schemas it extends through
+// allOf may find the same one.
+func (v *schemaValidator) report(path, format string, args ...any) {
+	problem := path + ": " + fmt.Sprintf(format, args...)
+	if len(v.problems) < maxValidationErrors && !slices.Contains(v.problems, problem) {
+		v.problems = append(v.problems, problem)
+	}
+}
+
+func (v *schemaValidator) check(schema map[string]any, value any, path string, depth int) {
+	if depth > maxSchemaDepth || value == nil {
+		return
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		resolved, _ := v.schemas[strings.TrimPrefix(ref, schemaRefPrefix)].(map[string]any)
+		if resolved != nil {
+			v.check(resolved, value, path, depth+1)
+		}
+		return
+	}
+	if allOf, ok := schema["allOf"].([]any); ok {
+		for _, sub := range allOf {
+			if s, ok := sub.(map[string]any); ok {
+				v.check(s, value, path, depth+1)
+			}
+		}
+	}
+	if enum, ok := schema["enum"].([]any); ok && !enumContains(enum, value) {
+		v.report(path, "is not one of the allowed values")
+		return
+	}
+
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			v.report(path, "must be an object")
+			return
+		}
+		v.checkObject(schema, obj, path, depth)
+	case "array":
+		arr, ok := value.([]any)
+		if !ok {
+			v.report(path, "must be an array")
+			return
+		}
+		if min, ok := schema["minItems"].(float64); ok && len(arr) < int(min) {
+			v.report(path, "must have at least %d items", int(min))
+		}
+		if max, ok := schema["maxItems"].(float64); ok && len(arr) > int(max) {
+			v.report(path, "must have at most %d items", int(max))
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range arr {
+				v.check(items, item, fmt.Sprintf("%s[%d]", path, i), depth+1)
+			}
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			v.report(path, "must be a string")
+			return
+		}
+		v.checkString(schema, s, path)
+	case "integer":
+		n, ok := value.(json.Number)
+		if _, err := n.Int64(); !ok || err != nil {
+			v.report(path, "must be an integer")
+			return
+		}
+		v.checkNumber(schema, n, path)
+	case "number":
+		n, ok := value.(json.Number)
+		if _, err := n.Float64(); !ok || err != nil {
+			v.report(path, "must be a number")
+			return
+		}
+		v.checkNumber(schema, n, path)
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			v.report(path, "must be a boolean")
+		}
+	}
+}
+
+func (v *schemaValidator) checkObject(schema, obj map[string]any, path string, depth int) {
+	required, _ := schema["required"].([]any)
+	for _, r := range required {
+		if name, ok := r.(string); ok && obj[name] == nil {
+			v.report(path+"."+name, "is required")
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]any)
+	additional, _ := schema["additionalProperties"].(map[string]any)
+	// Sorted, so that the same body always reports the same problems
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if prop, ok := properties[name].(map[string]any); ok {
+			v.check(prop, obj[name], path+"."+name, depth+1)
+		} else if additional != nil {
+			v.check(additional, obj[name], path+"."+name, depth+1)
+		}
+	}
+}
+
+func (v *schemaValidator) checkString(schema map[string]any, s, path string) {
+	length := utf8.RuneCountInString(s)
+	if min, ok := schema["minLength"].(float64); ok && length < int(min) {
+		v.report(path, "must be at least %d characters long", int(min))
+	}
+	if max, ok := schema["maxLength"].(float64); ok && length > int(max) {
+		v.report(path, "must be at most %d characters long", int(max))
+	}
+
+	var err error
+	switch schema["format"] {
+	case "email":
+		_, err = mail.ParseAddress(s)
+	case "uuid":
+		_, err = uuid.Parse(s)
+	case "date-time":
+		_, err = time.Parse(time.RFC3339, s)
+	}
+	if err != nil {
+		v.report(path, "must be a valid %s", schema["format"])
+	}
+}
+
+func (v *schemaValidator) checkNumber(schema map[string]any, n json.Number, path string) {
+	f, _ := n.Float64()
+	if min, ok := schema["minimum"].(float64); ok && f < min {
+		v.report(path, "must be at least %v", min)
+	}
+	if max, ok := schema["maximum"].(float64); ok && f > max {
+		v.report(path, "must be at most %v", max)
+	}
+}
+
+func enumContains(enum []any, value any) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testSchemas are the components of the test document, decoded from JSON like
+// a fetched document, so that numbers are float64.
+const testSchemas = `{
+	"Tag": {"type": "string", "minLength": 2, "maxLength": 5},
+	"Base": {"type": "object", "required": ["id"], "properties": {"id": {"type": "string", "format": "uuid"}}},
+	"Post": {
+		"allOf": [{"$ref": "#/components/schemas/Base"}],
+		"type": "object",
+		"required": ["title"],
+		"properties": {
+			"title": {"type": "string"},
+			"tags": {"type": "array", "minItems": 1, "maxItems": 2, "items": {"$ref": "#/components/schemas/Tag"}},
+			"rating": {"type": "integer", "minimum": 1, "maximum": 5},
+			"score": {"type": "number", "minimum": 0.5, "maximum": 9.5},
+			"published": {"type": "boolean"},
+			"email": {"type": "string", "format": "email"},
+			"at": {"type": "string", "format": "date-time"},
+			"status": {"type": "string", "enum": ["draft", "live"]},
+			"level": {"type": "integer", "enum": [1, 2]},
+			"meta": {"type": "object", "additionalProperties": {"type": "integer"}}
+		}
+	},
+	"Loop": {"$ref": "#/components/schemas/Loop"}
+}`
+
+func decodeJSON(t *testing.T, s string) map[string]any {
+	t.Helper()
+	var v map[string]any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("decoding %s: %v", s, err)
+	}
+	return v
+}
+
+func bodyOperation(t *testing.T, ref string) map[string]any {
+	return decodeJSON(t, `{"requestBody": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/`+ref+`"}}}}}`)
+}
+
+func TestValidateRequestBody(t *testing.T) {
+	schemas := decodeJSON(t, testSchemas)
+	op := bodyOperation(t, "Post")
+	const id = `"id": "8b4f3c1e-2c55-4a55-9f3e-4b8f7e6f1a2b"`
+
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"valid", `{` + id + `, "title": "t", "tags": ["go", "api"], "rating": 3, "score": 1.5, "published": true,
+			"email": "a@example.com", "at": "2024-01-02T03:04:05Z", "status": "live", "level": 2, "meta": {"a": 1}}`, nil},
+		{"required through allOf and properties", `{}`, []string{"body.id: is required", "body.title: is required"}},
+		{"null counts as absent", `{"id": null, "title": null}`, []string{"body.id: is required", "body.title: is required"}},
+		{"null optional value passes", `{` + id + `, "title": "t", "rating": null, "tags": null}`, nil},
+		{"not an object", `[]`, []string{"body: must be an object"}},
+		{"string type", `{` + id + `, "title": 1}`, []string{"body.title: must be a string"}},
+		{"integer type", `{` + id + `, "title": "t", "rating": 2.5}`, []string{"body.rating: must be an integer"}},
+		{"integer bounds", `{` + id + `, "title": "t", "rating": 0}`, []string{"body.rating: must be at least 1"}},
+		{"integer upper bound", `{` + id + `, "title": "t", "rating": 6}`, []string{"body.rating: must be at most 5"}},
+		{"number type", `{` + id + `, "title": "t", "score": "high"}`, []string{"body.score: must be a number"}},
+		{"number bounds", `{` + id + `, "title": "t", "score": 0.25}`, []string{"body.score: must be at least 0.5"}},
+		{"boolean type", `{` + id + `, "title": "t", "published": "yes"}`, []string{"body.published: must be a boolean"}},
+		{"array type", `{` + id + `, "title": "t", "tags": "go"}`, []string{"body.tags: must be an array"}},
+		{"min items", `{` + id + `, "title": "t", "tags": []}`, []string{"body.tags: must have at least 1 items"}},
+		{"max items", `{` + id + `, "title": "t", "tags": ["ab", "cd", "ef"]}`, []string{"body.tags: must have at most 2 items"}},
+		{"items through $ref", `{` + id + `, "title": "t", "tags": ["a", "toolong"]}`,
+			[]string{"body.tags[0]: must be at least 2 characters long", "body.tags[1]: must be at most 5 characters long"}},
+		{"uuid format", `{"id": "nope", "title": "t"}`, []string{"body.id: must be a valid uuid"}},
+		{"email format", `{` + id + `, "title": "t", "email": "nope"}`, []string{"body.email: must be a valid email"}},
+		{"date-time format", `{` + id + `, "title": "t", "at": "yesterday"}`, []string{"body.at: must be a valid date-time"}},
+		{"string enum", `{` + id + `, "title": "t", "status": "gone"}`, []string{"body.status: is not one of the allowed values"}},
+		{"numeric enum", `{` + id + `, "title": "t", "level": 3}`, []string{"body.level: is not one of the allowed values"}},
+		{"additional properties", `{` + id + `, "title": "t", "meta": {"a": "x"}}`, []string{"body.meta.a: must be an integer"}},
+		{"unknown properties are ignored", `{` + id + `, "title": "t", "extra": [1, "x"]}`, nil},
+		{"malformed JSON", `{"title": `, []string{"malformed JSON: unexpected EOF"}},
+		{"trailing data", `{` + id + `, "title": "t"} {}`, []string{"malformed JSON: unexpected data after the top-level value"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := validateRequestBody(op, schemas, []byte(tt.body))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateRequestBodyLimits(t *testing.T) {
+	schemas := decodeJSON(t, testSchemas)
+
+	t.Run("problems are capped", func(t *testing.T) {
+		op := decodeJSON(t, `{"requestBody": {"content": {"application/json": {"schema":
+			{"type": "array", "items": {"type": "string"}}}}}}`)
+		got := validateRequestBody(op, schemas, []byte(`[1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12]`))
+		if len(got) != maxValidationErrors {
+			t.Errorf("got %d problems, want %d", len(got), maxValidationErrors)
+		}
+	})
+	t.Run("reference cycles end", func(t *testing.T) {
+		if got := validateRequestBody(bodyOperation(t, "Loop"), schemas, []byte(`{}`)); got != nil {
+			t.Errorf("got %q, want no problems", got)
+		}
+	})
+	t.Run("operations without a JSON body schema", func(t *testing.T) {
+		if got := validateRequestBody(map[string]any{}, schemas, []byte(`not json`)); got != nil {
+			t.Errorf("got %q, want no problems", got)
+		}
+	})
+}
+
+func TestRequestValidationMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	route := &routeMapping{
+		Prefix:        "/api/v1/posts",
+		ServiceName:   "post-service",
+		RewritePrefix: "/post",
+		Request: requestRules{
+			MaxBodySize:    64,
+			ContentTypes:   []string{"application/json"},
+			ValidateSchema: true,
+		},
+	}
+	docs := &apiDocs{docs: map[string]*serviceDoc{
+		"post-service": {
+			doc: &openAPIDocument{
+				Paths: map[string]map[string]map[string]any{
+					"/post/{id}": {"put": bodyOperation(t, "Post")},
+				},
+				Components: openAPIComponents{Schemas: decodeJSON(t, testSchemas)},
+			},
+			fetchedAt: time.Now(),
+		},
+	}}
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("route", route) })
+	router.Use(requestValidationMiddleware(docs))
+	router.Any("/*path", func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, "%d", len(body))
+	})
+
+	const valid = `{"id": "8b4f3c1e-2c55-4a55-9f3e-4b8f7e6f1a2b", "title": "t"}`
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		chunked     bool
+		wantStatus  int
+		wantBody    map[string]any
+	}{
+		{name: "valid body", method: http.MethodPut, path: "/api/v1/posts/1", contentType: "application/json",
+			body: valid, wantStatus: http.StatusOK},
+		{name: "no body", method: http.MethodGet, path: "/api/v1/posts/1", wantStatus: http.StatusOK},
+		{name: "declared length over the limit", method: http.MethodPut, path: "/api/v1/posts/1", contentType: "application/json",
+			body: strings.Repeat(" ", 65), wantStatus: http.StatusRequestEntityTooLarge,
+			wantBody: map[string]any{"error": "Request body too large", "details": []any{"the limit is 64 bytes"}}},
+		{name: "unknown length over the limit", method: http.MethodPost, path: "/api/v1/posts", contentType: "application/json",
+			body: strings.Repeat(" ", 65), chunked: true, wantStatus: http.StatusRequestEntityTooLarge,
+			wantBody: map[string]any{"error": "Request body too large", "details": []any{"the limit is 64 bytes"}}},
+		{name: "unknown length within the limit", method: http.MethodPost, path: "/api/v1/posts", contentType: "application/json",
+			body: `{"a": 1}`, chunked: true, wantStatus: http.StatusOK},
+		{name: "unsupported content type", method: http.MethodPut, path: "/api/v1/posts/1", contentType: "text/plain",
+			body: "hello", wantStatus: http.StatusUnsupportedMediaType,
+			wantBody: map[string]any{"error": "Unsupported content type", "details": []any{"accepted: application/json"}}},
+		{name: "missing content type", method: http.MethodPut, path: "/api/v1/posts/1",
+			body: valid, wantStatus: http.StatusUnsupportedMediaType},
+		{name: "schema mismatch", method: http.MethodPut, path: "/api/v1/posts/1", contentType: "application/json; charset=utf-8",
+			body: `{"title": 1}`, wantStatus: http.StatusBadRequest,
+			wantBody: map[string]any{"error": "Request body does not match the API schema",
+				"details": []any{"body.id: is required", "body.title: must be a string"}}},
+		{name: "operation not in the document", method: http.MethodPost, path: "/api/v1/posts", contentType: "application/json",
+			body: `{"title": 1}`, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			req := httptest.NewRequest(tt.method, tt.path, body)
+			if tt.chunked {
+				req.ContentLength = -1
+				req.Body = io.NopCloser(req.Body)
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantBody != nil {
+				var got map[string]any
+				if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+					t.Fatalf("decoding %s: %v", w.Body, err)
+				}
+				if !reflect.DeepEqual(got, tt.wantBody) {
+					t.Errorf("body %v, want %v", got, tt.wantBody)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ----------------------------------
+// Version routing
+// ----------------------------------
+
+// versionRule sends the requests it matches to the instances registered with
+// Version. A rule matches on exactly one of a header, a cookie or a weight.
+type versionRule struct {
+	Version string      `yaml:"version" json:"version"`
+	Header  *valueMatch `yaml:"header" json:"header,omitempty"`
+	Cookie  *valueMatch `yaml:"cookie" json:"cookie,omitempty"`
+	Weight  *float64    `yaml:"weight" json:"weight,omitempty"` // percent of callers, by hash of user ID or client IP
+}
+
+// valueMatch matches a header or cookie with the given name and value.
+type valueMatch struct {
+	Name  string `yaml:"name" json:"name"`
+	Value string `yaml:"value" json:"value"`
+}
+
+func (m *routeMapping) validateVersions() error {
+	total := 0.0
+	for i, rule := range m.Versions {
+		matchers := 0
+		for _, set := range []bool{rule.Header != nil, rule.Cookie != nil, rule.Weight != nil} {
+			if set {
+				matchers++
+			}
+		}
+		switch {
+		case rule.Version == "":
+			return fmt.Errorf("route %s: version rule %d: version is required", m.Prefix, i)
+		case matchers != 1:
+			return fmt.Errorf("route %s: version rule %d: exactly one of header, cookie and weight is required", m.Prefix, i)
+		case rule.Header != nil && (rule.Header.Name == "" || rule.Header.Value == ""):
+			return fmt.Errorf("route %s: version rule %d: header needs a name and a value", m.Prefix, i)
+		case rule.Cookie != nil && (rule.Cookie.Name == "" || rule.Cookie.Value == ""):
+			return fmt.Errorf("route %s: version rule %d: cookie needs a name and a value", m.Prefix, i)
+		case rule.Weight != nil && (*rule.Weight < 0 || *rule.Weight > 100):
+			return fmt.Errorf("route %s: version rule %d: weight must be between 0 and 100", m.Prefix, i)
+		}
+		if rule.Weight != nil {
+			total += *rule.Weight
+		}
+	}
+	if total > 100 {
+		return fmt.Errorf("route %s: version weights add up to more than 100", m.Prefix)
+	}
+	return nil
+}
+
+// selectVersion returns the version the first matching rule routes the
+// request to, or "" when no rule matches. Weights are applied to a bucket
+// derived from the caller, so a caller keeps getting the same version for as
+// long as the weights stay the same.
+func (m *routeMapping) selectVersion(c *gin.Context) string {
+	bucket := -1.0
+	cumulative := 0.0
+	for _, rule := range m.Versions {
+		switch {
+		case rule.Header != nil:
+			if c.GetHeader(rule.Header.Name) == rule.Header.Value {
+				return rule.Version
+			}
+		case rule.Cookie != nil:
+			if v, err := c.Cookie(rule.Cookie.Name); err == nil && v == rule.Cookie.Value {
+				return rule.Version
+			}
+		default:
+			if bucket < 0 {
+				bucket = callerBucket(m.ServiceName, c)
+			}
+			cumulative += *rule.Weight
+			if bucket < cumulative {
+				return rule.Version
+			}
+		}
+	}
+	return ""
+}
+
+// callerBucket places the caller in [0, 100), by user ID when authenticated
+// and by client IP otherwise. The service name is mixed in so that the same
+// callers are not always the first to get every service's canary.
+func callerBucket(service string, c *gin.Context) float64 {
+	key := "ip:" + c.ClientIP()
+	if uid, ok := c.Get("user_id"); ok {
+		key = fmt.Sprintf("user:%v", uid)
+	}
+	h := fnv.New64a()
+	h.Write([]byte(service + "\x00" + key))
+	return float64(h.Sum64()%10000) / 100
+}
+
+// versionInstances narrows instances to those of version. With no version,
+// or when none of its instances is available, requests go to the instances
+// of versions no rule routes to, so that a failing canary falls back to the
+// stable release. When there are none of those either, every instance is
+// eligible.
+func (m *routeMapping) versionInstances(instances []*upstreamInstance, version string) []*upstreamInstance {
+	if len(m.Versions) == 0 {
+		return instances
+	}
+	if version != "" {
+		if selected := filterInstances(instances, func(inst *upstreamInstance) bool { return inst.Version == version }); len(selected) > 0 {
+			return selected
+		}
+	}
+	routed := make(map[string]bool, len(m.Versions))
+	for _, rule := range m.Versions {
+		routed[rule.Version] = true
+	}
+	if stable := filterInstances(instances, func(inst *upstreamInstance) bool { return !routed[inst.Version] }); len(stable) > 0 {
+		return stable
+	}
+	return instances
+}
+
+func filterInstances(instances []*upstreamInstance, keep func(*upstreamInstance) bool) []*upstreamInstance {
+	var kept []*upstreamInstance
+	for _, inst := range instances {
+		if keep(inst) {
+			kept = append(kept, inst)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// ----------------------------------
+// Composed views
+// ----------------------------------
+
+// A view gathers everything a page needs from several services in one
+// response. Sections are fetched concurrently by public path, and go through
+// the route that forwards the path like any proxied request: its rewrite,
+// version rules, timeout and connection pool apply. A failing upstream does
+// not fail the view; its section carries the error instead.
+
+const (
+	// maxViewBody caps how much of an upstream response a view reads.
+	maxViewBody = 4 << 20
+	// viewProfileConcurrency caps concurrent profile lookups per view.
+	viewProfileConcurrency = 8
+)
+
+// viewSection is one upstream's contribution to a view: the data field of
+// its response envelope, or the error that prevented it.
+type viewSection struct {
+	Data  json.RawMessage `json:"data,omitempty"`
+	Error *sectionError   `json:"error,omitempty"`
+}
+
+type sectionError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+func (s viewSection) failed() bool {
+	return s.Error != nil
+}
+
+// postView is the document served by GET /api/v1/views/post/:id. Profiles
+// are keyed by user ID and cover the post's author and every commenter.
+type postView struct {
+	Post     viewSection            `json:"post"`
+	Comments viewSection            `json:"comments"`
+	Profiles map[string]viewSection `json:"profiles"`
+}
+
+// viewComposer fetches view sections from upstream instances, with the same
+// instance selection, circuit breaking and connections as the proxy.
+type viewComposer struct {
+	disc   *Discovery
+	lb     balancer
+	signer *identitySigner
+	logger *logrus.Logger
+}
+
+func newViewComposer(disc *Discovery, lb balancer, signer *identitySigner, logger *logrus.Logger) *viewComposer {
+	return &viewComposer{
+		disc:   disc,
+		lb:     lb,
+		signer: signer,
+		logger: logger,
+	}
+}
+
+// postViewHandler serves a post together with its comments and the profiles
+// of everyone involved. The view fails only when the post itself does.
+func (vc *viewComposer) postViewHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		postID := c.Param("id")
+		if _, err := uuid.Parse(postID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid post ID format"})
+			return
+		}
+
+		var view postView
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			view.Post = vc.fetch(c, "/api/v1/posts/"+postID)
+		}()
+		go func() {
+			defer wg.Done()
+			view.Comments = vc.fetch(c, "/api/v1/comments/post/"+postID)
+		}()
+		wg.Wait()
+
+		view.Profiles = vc.fetchProfiles(c, viewUserIDs(view.Post, view.Comments))
+
+		status := http.StatusOK
+		if view.Post.failed() {
+			status = http.StatusBadGateway
+			if view.Post.Error.Status == http.StatusNotFound {
+				status = http.StatusNotFound
+			}
+		}
+		c.JSON(status, view)
+	}
+}
+
+// viewUserIDs returns the distinct user IDs of the post's author and its
+// commenters, author first.
+func viewUserIDs(post, comments viewSection) []string {
+	var owner struct {
+		UserID string `json:"user_id"`
+	}
+	var commenters []struct {
+		UserID string `json:"user_id"`
+	}
+	if !post.failed() {
+		json.Unmarshal(post.Data, &owner)
+	}
+	if !comments.failed() {
+		json.Unmarshal(comments.Data, &commenters)
+	}
+
+	seen := make(map[string]bool)
+	var ids []string
+	add := func(id string) {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	add(owner.UserID)
+	for _, cm := range commenters {
+		add(cm.UserID)
+	}
+	return ids
+}
+
+func (vc *viewComposer) fetchProfiles(c *gin.Context, userIDs []string) map[string]viewSection {
+	profiles := make(map[string]viewSection, len(userIDs))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, viewProfileConcurrency)
+	for _, id := range userIDs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			section := vc.fetch(c, "/api/v1/profile/"+url.PathEscape(id))
+			mu.Lock()
+			profiles[id] = section
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return profiles
+}
+
+// fetch performs a GET of target, a public path with an optional query, on
+// an instance of the service its route forwards to, and returns the data of
+// the response envelope. The caller's identity is forwarded as for proxied
+// requests.
+func (vc *viewComposer) fetch(c *gin.Context, target string) viewSection {
+	path, query, _ := strings.Cut(target, "?")
+	route := routeTableFromContext(c).match(path)
+	if route == nil {
+		vc.logger.WithField("path", path).Error("no route for view section")
+		return sectionFailure(http.StatusBadGateway, "Bad gateway")
+	}
+	service := route.ServiceName
+	if len(vc.disc.Instances(service)) == 0 {
+		upstreamError(service, upstreamNoInstances)
+		return sectionFailure(http.StatusServiceUnavailable, "Service unavailable")
+	}
+	version := route.selectVersion(c)
+	available := route.versionInstances(vc.disc.AvailableInstances(service), version)
+	if len(available) == 0 && !breakersOpen(route.versionInstances(vc.disc.Instances(service), version)) {
+		upstreamError(service, upstreamNoInstances)
+		return sectionFailure(http.StatusServiceUnavailable, "Service unavailable")
+	}
+	instance := pickInstance(vc.lb, service, available, nil)
+	if instance == nil {
+		upstreamError(service, upstreamCircuitOpen)
+		return sectionFailure(http.StatusServiceUnavailable, "Service temporarily unavailable")
+	}
+	done := instance.acquire()
+	defer done()
+
+	transport, err := instance.roundTripper(route.Transport, vc.logger)
+	if err != nil {
+		instance.breaker.Ignore()
+		vc.logger.WithError(err).WithField("instance", instance.ID).Error("invalid service address")
+		return sectionFailure(http.StatusInternalServerError, "Invalid service address")
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), route.timeout())
+	defer cancel()
+
+	upstreamURL := instance.Address + upstreamPath(route, path)
+	if query != "" {
+		upstreamURL += "?" + query
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, upstreamURL, nil)
+	if err != nil {
+		instance.breaker.Ignore()
+		vc.logger.WithError(err).WithField("instance", instance.ID).Error("invalid view request")
+		return sectionFailure(http.StatusInternalServerError, "Invalid service address")
+	}
+	req.Header.Set("Accept", "application/json")
+	if err := vc.signer.attachIdentity(req, service, c); err != nil {
+		vc.logger.WithError(err).Error("failed to sign identity assertion")
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		if errors.Is(c.Request.Context().Err(), context.Canceled) {
+			instance.breaker.Ignore()
+			return sectionFailure(http.StatusBadGateway, "Request canceled")
+		}
+		instance.breaker.Failure()
+		reason := transportErrorReason(err)
+		upstreamError(service, reason)
+		vc.logger.WithError(err).WithFields(logrus.Fields{"service": service, "instance": instance.ID}).Warn("view upstream error")
+		if reason == upstreamTimeout {
+			return sectionFailure(http.StatusGatewayTimeout, "Upstream timed out")
+		}
+		return sectionFailure(http.StatusBadGateway, "Bad gateway")
+	}
+	defer resp.Body.Close()
+
+	if isUpstreamFailure(resp.StatusCode) {
+		instance.breaker.Failure()
+		upstreamError(service, upstreamBadStatus)
+	} else {
+		instance.breaker.Success()
+	}
+
+	var envelope struct {
+		Data    json.RawMessage `json:"data"`
+		Message string          `json:"message"`
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxViewBody))
+	if err == nil {
+		err = json.Unmarshal(body, &envelope)
+	}
+	if resp.StatusCode != http.StatusOK {
+		message := envelope.Message
+		if message == "" {
+			message = http.StatusText(resp.StatusCode)
+		}
+		return sectionFailure(resp.StatusCode, message)
+	}
+	if err != nil {
+		return sectionFailure(http.StatusBadGateway, fmt.Sprintf("Invalid upstream response: %v", err))
+	}
+	return viewSection{Data: envelope.Data}
+}
+
+func sectionFailure(status int, message string) viewSection {
+	return viewSection{Error: &sectionError{Status: status, Message: message}}
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/omsurase/blogger_microservices/server/auth/internal/models"
+)
+
+const (
+	// apiKeyPrefix starts every key, so that leaked keys are easy to spot.
+	apiKeyPrefix = "blg_"
+	// apiKeyRedisPrefix namespaces the keys the gateway resolves, by hash.
+	apiKeyRedisPrefix     = "apikey:"
+	defaultAPIKeyLifetime = 90
+)
+
+// apiKeyRecord is what the gateway reads from Redis to resolve a key.
+type apiKeyRecord struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	Scopes    []string  `json:"scopes"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// publishAPIKey makes a key resolvable by the gateway until it expires.
+func (h *AuthHandler) publishAPIKey(ctx context.Context, key *models.APIKey) error {
+	record, err := json.Marshal(apiKeyRecord{
+		ID:        key.ID.String(),
+		UserID:    key.UserID.String(),
+		Scopes:    key.Scopes,
+		ExpiresAt: key.ExpiresAt,
+	})
+	if err != nil {
+		return err
+	}
+	ttl := time.Until(key.ExpiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return h.redis.Set(ctx, apiKeyRedisPrefix+key.KeyHash, record, ttl).Err()
+}
+
+// SyncAPIKeys publishes every active key, so that keys survive a loss of
+// Redis data.
+func (h *AuthHandler) SyncAPIKeys(ctx context.Context) error {
+	keys, err := h.store.GetActiveAPIKeys()
+	if err != nil {
+		return err
+	}
+	for i := range keys {
+		if err := h.publishAPIKey(ctx, &keys[i]); err != nil {
+			return err
+		}
+	}
+	log.Printf("Published %d API keys", len(keys))
+	return nil
+}
+
+func callerID(c *gin.Context) (uuid.UUID, error) {
+	return uuid.Parse(fmt.Sprintf("%v", c.MustGet("user_id")))
+}
+
+func (h *AuthHandler) CreateAPIKey(c *gin.Context) {
+	if c.ContentType() != "application/json" {
+		sendError(c, http.StatusUnsupportedMediaType, "Content-Type must be application/json", nil)
+		return
+	}
+
+	userID, err := callerID(c)
+	if err != nil {
+		sendError(c, http.StatusUnauthorized, "Invalid user ID in token", err)
+		return
+	}
+
+	var req models.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		sendError(c, http.StatusBadRequest, "Invalid request payload", err)
+		return
+	}
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = defaultAPIKeyLifetime
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		sendError(c, http.StatusInternalServerError, "Failed to generate API key", err)
+		return
+	}
+	plain := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	now := time.Now()
+	key := &models.APIKey{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Name:      req.Name,
+		Prefix:    plain[:len(apiKeyPrefix)+8],
+		KeyHash:   hashAPIKey(plain),
+		Scopes:    req.Scopes,
+		ExpiresAt: now.AddDate(0, 0, req.ExpiresInDays),
+		CreatedAt: now,
+	}
+
+	if err := h.store.CreateAPIKey(key); err != nil {
+		sendError(c, http.StatusInternalServerError, "Failed to create API key", err)
+		return
+	}
+	if err := h.publishAPIKey(c.Request.Context(), key); err != nil {
+		// The key cannot be used; do not hand it out
+		h.store.DeleteAPIKey(key.ID, userID)
+		sendError(c, http.StatusInternalServerError, "Failed to create API key", err)
+		return
+	}
+
+	sendSuccess(c, http.StatusCreated, models.CreateAPIKeyResponse{APIKey: *key, Key: plain})
+}
+
+func (h *AuthHandler) ListAPIKeys(c *gin.Context) {
+	userID, err := callerID(c)
+	if err != nil {
+		sendError(c, http.StatusUnauthorized, "Invalid user ID in token", err)
+		return
+	}
+
+	keys, err := h.store.GetAPIKeysByUser(userID)
+	if err != nil {
+		sendError(c, http.StatusInternalServerError, "Failed to fetch API keys", err)
+		return
+	}
+
+	sendSuccess(c, http.StatusOK, keys)
+}
+
+func (h *AuthHandler) DeleteAPIKey(c *gin.Context) {
+	userID, err := callerID(c)
+	if err != nil {
+		sendError(c, http.StatusUnauthorized, "Invalid user ID in token", err)
+		return
+	}
+
+	keyID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		sendError(c, http.StatusBadRequest, "Invalid API key ID format", err)
+		return
+	}
+
+	key, err := h.store.DeleteAPIKey(keyID, userID)
+	if err != nil {
+		sendError(c, http.StatusInternalServerError, "Failed to delete API key", err)
+		return
+	}
+	if key == nil {
+		sendError(c, http.StatusNotFound, "API key not found", nil)
+		return
+	}
+
+	if err := h.redis.Del(c.Request.Context(), apiKeyRedisPrefix+key.KeyHash).Err(); err != nil {
+		sendError(c, http.StatusInternalServerError, "Failed to revoke API key", err)
+		return
+	}
+
+	sendSuccess(c, http.StatusOK, gin.H{"message": "API key revoked"})
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/omsurase/blogger_microservices/server/auth/internal/models"
+	"github.com/omsurase/blogger_microservices/server/pkg/openapi"
+)
+
+// APISpec documents the routes main registers; main checks that the two
+// agree.
+func APISpec(version string) *openapi.Spec {
+	spec := openapi.New("Auth Service", version, models.SuccessResponse{}, models.ErrorResponse{})
+	spec.Add(
+		openapi.Operation{
+			Method: http.MethodPost, Path: "/auth/signup", Summary: "Create an account",
+			Request: models.SignupRequest{}, Response: models.User{}, Status: http.StatusCreated,
+			Errors: []int{http.StatusBadRequest, http.StatusConflict, http.StatusUnsupportedMediaType, http.StatusInternalServerError},
+		},
+		openapi.Operation{
+			Method: http.MethodPost, Path: "/auth/login", Summary: "Exchange credentials for a token",
+			Request: models.LoginRequest{}, Response: models.TokenResponse{},
+			Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusUnsupportedMediaType, http.StatusInternalServerError},
+		},
+		openapi.Operation{
+			Method: http.MethodGet, Path: "/auth/validate-token", Summary: "Validate the caller's token", Auth: true,
+			Response: models.ValidationResponse{},
+			Errors:   []int{http.StatusUnauthorized, http.StatusInternalServerError},
+		},
+		openapi.Operation{
+			Method: http.MethodPost, Path: "/auth/logout", Summary: "Revoke the caller's token", Auth: true,
+			Response: openapi.Message{},
+			Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusInternalServerError},
+		},
+		openapi.Operation{
+			Method: http.MethodGet, Path: "/auth/users/:id", Summary: "Get a user",
+			Response: models.User{},
+			Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError},
+		},
+		openapi.Operation{
+			Method: http.MethodPost, Path: "/auth/api-keys", Summary: "Create an API key; the key is only ever returned here", Auth: true,
+			Request: models.CreateAPIKeyRequest{}, Response: models.CreateAPIKeyResponse{}, Status: http.StatusCreated,
+			Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusUnsupportedMediaType, http.StatusInternalServerError},
+		},
+		openapi.Operation{
+			Method: http.MethodGet, Path: "/auth/api-keys", Summary: "List the caller's API keys", Auth: true,
+			Response: []models.APIKey{},
+			Errors:   []int{http.StatusUnauthorized, http.StatusInternalServerError},
+		},
+		openapi.Operation{
+			Method: http.MethodDelete, Path: "/auth/api-keys/:id", Summary: "Revoke an API key of the caller", Auth: true,
+			Response: openapi.Message{},
+			Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusInternalServerError},
+		},
+	)
+	return spec
+}
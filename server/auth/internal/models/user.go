@@ -42,4 +42,31 @@ type ErrorResponse struct {
 type SuccessResponse struct {
 	Status int         `json:"status"`
 	Data   interface{} `json:"data"`
-} 
\ No newline at end of file
+} 
+
+// APIKey authenticates a machine client as the user who created it. Only
+// the SHA-256 hash of the key is stored; Prefix identifies it to its owner.
+type APIKey struct {
+	ID        uuid.UUID `json:"id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Name      string    `json:"name"`
+	Prefix    string    `json:"prefix"`
+	KeyHash   string    `json:"-"`
+	Scopes    []string  `json:"scopes"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+	// Scopes limit what the key may do on the caller's behalf. Sessions from
+	// /auth/login are not limited.
+	Scopes        []string `json:"scopes" binding:"required,min=1,dive,oneof=posts:write comments:write profile:read profile:write"`
+	ExpiresInDays int      `json:"expires_in_days" binding:"omitempty,min=1,max=365"`
+}
+
+// CreateAPIKeyResponse is the only time the key itself is returned.
+type CreateAPIKeyResponse struct {
+	APIKey
+	Key string `json:"key"`
+}
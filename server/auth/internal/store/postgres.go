@@ -5,7 +5,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/omsurase/blogger_microservices/server/auth/internal/models"
 )
 
@@ -35,6 +35,18 @@ func (s *PostgresStore) InitDB() error {
 			created_at TIMESTAMP NOT NULL,
 			updated_at TIMESTAMP NOT NULL
 		);
+
+		CREATE TABLE IF NOT EXISTS api_keys (
+			id UUID PRIMARY KEY,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name VARCHAR(100) NOT NULL,
+			prefix VARCHAR(16) NOT NULL,
+			key_hash CHAR(64) UNIQUE NOT NULL,
+			scopes TEXT[] NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);
 	`
 	_, err := s.db.Exec(query)
 	return err
@@ -127,6 +139,109 @@ func (s *PostgresStore) GetUserByID(userID string) (*models.User, error) {
 	return user, nil
 }
 
+func (s *PostgresStore) CreateAPIKey(key *models.APIKey) error {
+	query := `
+		INSERT INTO api_keys (id, user_id, name, prefix, key_hash, scopes, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+
+	_, err := s.db.Exec(
+		query,
+		key.ID,
+		key.UserID,
+		key.Name,
+		key.Prefix,
+		key.KeyHash,
+		pq.Array(key.Scopes),
+		key.ExpiresAt,
+		key.CreatedAt,
+	)
+	return err
+}
+
+// GetAPIKeysByUser returns the keys of a user, newest first.
+func (s *PostgresStore) GetAPIKeysByUser(userID uuid.UUID) ([]models.APIKey, error) {
+	return s.queryAPIKeys(`
+		SELECT id, user_id, name, prefix, key_hash, scopes, expires_at, created_at
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`, userID)
+}
+
+// GetActiveAPIKeys returns every key that has not expired.
+func (s *PostgresStore) GetActiveAPIKeys() ([]models.APIKey, error) {
+	return s.queryAPIKeys(`
+		SELECT id, user_id, name, prefix, key_hash, scopes, expires_at, created_at
+		FROM api_keys
+		WHERE expires_at > $1
+	`, time.Now())
+}
+
+func (s *PostgresStore) queryAPIKeys(query string, args ...interface{}) ([]models.APIKey, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		var key models.APIKey
+		if err := rows.Scan(
+			&key.ID,
+			&key.UserID,
+			&key.Name,
+			&key.Prefix,
+			&key.KeyHash,
+			pq.Array(&key.Scopes),
+			&key.ExpiresAt,
+			&key.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// DeleteAPIKey deletes a key of a user and returns it, or nil when the user
+// has no such key.
+func (s *PostgresStore) DeleteAPIKey(id, userID uuid.UUID) (*models.APIKey, error) {
+	key := &models.APIKey{}
+	query := `
+		DELETE FROM api_keys
+		WHERE id = $1 AND user_id = $2
+		RETURNING id, user_id, name, prefix, key_hash, scopes, expires_at, created_at
+	`
+
+	err := s.db.QueryRow(query, id, userID).Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+		pq.Array(&key.Scopes),
+		&key.ExpiresAt,
+		&key.CreatedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
 func (s *PostgresStore) Close() error {
 	return s.db.Close()
-} 
\ No newline at end of file
+}
+
+// DB returns the underlying connection pool, for metrics.
+func (s *PostgresStore) DB() *sql.DB {
+	return s.db
+}
@@ -13,8 +13,11 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"github.com/omsurase/blogger_microservices/server/auth/internal/handlers"
+	"github.com/omsurase/blogger_microservices/server/pkg/metrics"
 	"github.com/omsurase/blogger_microservices/server/auth/internal/store"
+	"github.com/omsurase/blogger_microservices/server/pkg/tracing"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -31,6 +34,13 @@ func main() {
 		port = "8080"
 	}
 
+	metrics.Init(serviceName)
+
+	shutdownTracing, err := tracing.Init(context.Background(), serviceName)
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+
 	dbURL := os.Getenv("DATABASE_URL")
 	if dbURL == "" {
 		log.Fatal("DATABASE_URL environment variable is required")
@@ -45,6 +55,7 @@ func main() {
 	if err := store.InitDB(); err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
+	metrics.RegisterDB(store.DB(), "postgres")
 
 	// Initialize Redis client
 	redisAddr := os.Getenv("REDIS_ADDR")
@@ -57,12 +68,21 @@ func main() {
 	})
 
 	// simple ping to confirm connectivity (non-fatal if fails, but log)
+	metrics.RegisterRedis(func() metrics.RedisPoolStats { return metrics.RedisPoolStats(*redisClient.PoolStats()) }, "redis")
 	if err := redisClient.Ping(context.Background()).Err(); err != nil {
 		log.Printf("Warning: failed to connect to Redis at %s: %v", redisAddr, err)
 	}
 
 	authHandler := handlers.NewAuthHandler(store, redisClient)
+	// The gateway resolves API keys through Redis; republish them in case
+	// its data was lost
+	if err := authHandler.SyncAPIKeys(context.Background()); err != nil {
+		log.Printf("Warning: failed to publish API keys: %v", err)
+	}
 	router := gin.Default()
+	router.Use(otelgin.Middleware(serviceName))
+	router.Use(metrics.Middleware())
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
 
 	// Add health check endpoint under auth prefix
 	router.GET("/auth/health", func(c *gin.Context) {
@@ -77,6 +97,16 @@ func main() {
 	router.GET("/auth/validate-token", handlers.AuthMiddleware(), authHandler.ValidateToken)
 	router.POST("/auth/logout", handlers.AuthMiddleware(), authHandler.Logout)
 	router.GET("/auth/users/:id", authHandler.GetUserByID)
+	router.POST("/auth/api-keys", handlers.AuthMiddleware(), authHandler.CreateAPIKey)
+	router.GET("/auth/api-keys", handlers.AuthMiddleware(), authHandler.ListAPIKeys)
+	router.DELETE("/auth/api-keys/:id", handlers.AuthMiddleware(), authHandler.DeleteAPIKey)
+
+	// OpenAPI document of the routes above, merged into the gateway's docs
+	apiSpec := handlers.APISpec(os.Getenv("SERVICE_VERSION"))
+	router.GET("/auth/openapi.json", apiSpec.Handler())
+	if err := apiSpec.Check(router.Routes(), "/metrics", "/auth/health", "/auth/openapi.json"); err != nil {
+		log.Fatalf("API documentation is out of date: %v", err)
+	}
 
 	srv := &http.Server{
 		Addr:    fmt.Sprintf(":%s", port),
@@ -107,6 +137,10 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
+
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("Failed to flush traces: %v", err)
+	}
 }
 
 func registerService() error {
@@ -125,8 +159,10 @@ func registerService() error {
 
 	for i := 0; i < retryAttempts; i++ {
 		payload := map[string]string{
+			"id":      hostname,
 			"name":    serviceName,
 			"address": serviceAddress,
+			"version": os.Getenv("SERVICE_VERSION"),
 		}
 
 		jsonPayload, err := json.Marshal(payload)
@@ -154,10 +190,18 @@ func startHeartbeat() {
 	registryURL := os.Getenv("REGISTRY_URL")
 	heartbeatURL := fmt.Sprintf("%s/heartbeat", registryURL)
 
+	// The hostname doubles as the instance ID used at registration time.
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Printf("Failed to get hostname for heartbeat: %v", err)
+		return
+	}
+
 	ticker := time.NewTicker(heartbeatDelay)
 	go func() {
 		for range ticker.C {
 			payload := map[string]string{
+				"id":   hostname,
 				"name": serviceName,
 			}
 
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -15,17 +16,20 @@ import (
 	"github.com/omsurase/blogger_microservices/server/comment/internal/models"
 	"github.com/omsurase/blogger_microservices/server/comment/internal/publisher"
 	"github.com/omsurase/blogger_microservices/server/comment/internal/store"
+	"github.com/omsurase/blogger_microservices/server/pkg/tracing"
 )
 
 type CommentHandler struct {
-	store     *store.PostgresStore
-	publisher *publisher.RabbitMQPublisher
+	store      *store.PostgresStore
+	publisher  *publisher.RabbitMQPublisher
+	httpClient *http.Client
 }
 
 func NewCommentHandler(store *store.PostgresStore, publisher *publisher.RabbitMQPublisher) *CommentHandler {
 	return &CommentHandler{
-		store:     store,
-		publisher: publisher,
+		store:      store,
+		publisher:  publisher,
+		httpClient: tracing.NewClient(),
 	}
 }
 
@@ -49,7 +53,7 @@ func sendSuccess(c *gin.Context, status int, data interface{}) {
 	})
 }
 
-func (h *CommentHandler) getPost(postID string) (*models.Post, error) {
+func (h *CommentHandler) getPost(ctx context.Context, postID string) (*models.Post, error) {
     postServiceURL := os.Getenv("POST_SERVICE_URL")
     if postServiceURL == "" {
         postServiceURL = "http://post-service:8080"
@@ -57,7 +61,11 @@ func (h *CommentHandler) getPost(postID string) (*models.Post, error) {
 
     postURL := fmt.Sprintf("%s/post/%s", postServiceURL, postID)
     log.Printf("Fetching post details from: %s", postURL)
-    resp, err := http.Get(postURL)
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, postURL, nil)
+    if err != nil {
+        return nil, fmt.Errorf("failed to build post request: %v", err)
+    }
+    resp, err := h.httpClient.Do(req)
     if err != nil {
         log.Printf("Failed to fetch post: %v", err)
         return nil, fmt.Errorf("failed to fetch post: %v", err)
@@ -105,7 +113,7 @@ func (h *CommentHandler) CreateComment(c *gin.Context) {
 		return
 	}
 
-	post, err := h.getPost(req.PostID)
+	post, err := h.getPost(c.Request.Context(), req.PostID)
 	if err != nil {
 		sendError(c, http.StatusInternalServerError, "Failed to fetch post details", err)
 		return
@@ -142,7 +150,7 @@ func (h *CommentHandler) CreateComment(c *gin.Context) {
 	eventJSON, _ := json.Marshal(event)
 	log.Printf("Publishing comment event: %s", string(eventJSON))
 
-	if err := h.publisher.PublishNewComment(event); err != nil {
+	if err := h.publisher.PublishNewComment(c.Request.Context(), event); err != nil {
 		log.Printf("Failed to publish comment event: %v", err)
 		sendError(c, http.StatusInternalServerError, "Failed to publish comment event", err)
 		return
@@ -215,16 +223,16 @@ func (h *CommentHandler) DeleteComment(c *gin.Context) {
 	sendSuccess(c, http.StatusOK, map[string]string{"message": "Comment deleted successfully"})
 }
 
+// AuthMiddleware requires the caller identity established by the identity
+// middleware from the gateway's signed assertion.
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userID := c.GetHeader("X-User-ID")
-		if userID == "" {
-			sendError(c, http.StatusUnauthorized, "X-User-ID header missing", nil)
+		if c.GetString("user_id") == "" {
+			sendError(c, http.StatusUnauthorized, "Authentication required", nil)
 			c.Abort()
 			return
 		}
 
-		c.Set("user_id", userID)
 		c.Next()
 	}
 } 
\ No newline at end of file
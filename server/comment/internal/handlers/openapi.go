@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/omsurase/blogger_microservices/server/comment/internal/models"
+	"github.com/omsurase/blogger_microservices/server/pkg/openapi"
+)
+
+// APISpec documents the routes main registers; main checks that the two
+// agree.
+func APISpec(version string) *openapi.Spec {
+	spec := openapi.New("Comment Service", version, models.SuccessResponse{}, models.ErrorResponse{})
+	spec.Add(
+		openapi.Operation{
+			Method: http.MethodPost, Path: "/comment/create", Summary: "Comment on a post", Auth: true,
+			Request: models.CreateCommentRequest{}, Response: models.Comment{}, Status: http.StatusCreated,
+			Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusInternalServerError},
+		},
+		openapi.Operation{
+			Method: http.MethodGet, Path: "/comment/post/:postId", Summary: "List the comments on a post",
+			Response: []models.Comment{},
+			Errors:   []int{http.StatusBadRequest, http.StatusInternalServerError},
+		},
+		openapi.Operation{
+			Method: http.MethodDelete, Path: "/comment/:id", Summary: "Delete a comment of the caller", Auth: true,
+			Response: openapi.Message{},
+			Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusInternalServerError},
+		},
+	)
+	return spec
+}
@@ -16,7 +16,7 @@ type Comment struct {
 
 type CreateCommentRequest struct {
 	PostID  string `json:"post_id" binding:"required"`
-	Content string `json:"content" binding:"required"`
+	Content string `json:"content" binding:"required,max=5000"`
 }
 
 type CommentEvent struct {
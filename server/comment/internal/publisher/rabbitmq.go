@@ -1,18 +1,29 @@
 package publisher
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"time"
 
 	"github.com/omsurase/blogger_microservices/server/comment/internal/models"
+	"github.com/omsurase/blogger_microservices/server/pkg/metrics"
+	"github.com/omsurase/blogger_microservices/server/pkg/tracing"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type RabbitMQPublisher struct {
 	conn    *amqp.Connection
 	channel *amqp.Channel
+
+	published *prometheus.CounterVec
 }
 
 func NewRabbitMQPublisher(url string) (*RabbitMQPublisher, error) {
@@ -53,6 +64,10 @@ func NewRabbitMQPublisher(url string) (*RabbitMQPublisher, error) {
 	return &RabbitMQPublisher{
 		conn:    conn,
 		channel: ch,
+		published: metrics.NewCounterVec(prometheus.CounterOpts{
+			Name: "rabbitmq_messages_published_total",
+			Help: "RabbitMQ messages published, by exchange and outcome.",
+		}, "exchange", "outcome"),
 	}, nil
 }
 
@@ -63,13 +78,39 @@ func (p *RabbitMQPublisher) Close() error {
 	return p.conn.Close()
 }
 
-func (p *RabbitMQPublisher) PublishNewComment(event *models.CommentEvent) error {
+func (p *RabbitMQPublisher) PublishNewComment(ctx context.Context, event *models.CommentEvent) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "events_exchange publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemRabbitMQ,
+			semconv.MessagingOperationTypeSend,
+			semconv.MessagingDestinationName("events_exchange"),
+			attribute.String("comment.id", event.CommentID),
+		),
+	)
+	defer func() {
+		outcome := "success"
+		if err != nil {
+			outcome = "error"
+		}
+		p.published.WithLabelValues("events_exchange", outcome).Inc()
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
 	body, err := json.Marshal(event)
 	if err != nil {
 		log.Printf("Failed to marshal event: %v", err)
 		return fmt.Errorf("failed to marshal event: %v", err)
 	}
 
+	// The consumer continues the trace from the message headers
+	headers := amqp.Table{}
+	otel.GetTextMapPropagator().Inject(ctx, tracing.HeaderCarrier(headers))
+
 	log.Printf("Publishing message to events_exchange: %s", string(body))
 	err = p.channel.Publish(
 		"events_exchange",
@@ -78,8 +119,9 @@ func (p *RabbitMQPublisher) PublishNewComment(event *models.CommentEvent) error
 		false,
 		amqp.Publishing{
 			ContentType: "application/json",
-			Body:       body,
-			Timestamp:  time.Now(),
+			Headers:     headers,
+			Body:        body,
+			Timestamp:   time.Now(),
 		},
 	)
 	if err != nil {
@@ -135,4 +177,4 @@ func (p *RabbitMQPublisher) Reconnect(url string) error {
 	p.conn = conn
 	p.channel = ch
 	return nil
-} 
\ No newline at end of file
+}
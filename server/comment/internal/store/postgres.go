@@ -130,4 +130,9 @@ func (s *PostgresStore) DeleteComment(id uuid.UUID) error {
 	}
 
 	return nil
-} 
\ No newline at end of file
+}
+
+// DB returns the underlying connection pool, for metrics.
+func (s *PostgresStore) DB() *sql.DB {
+	return s.db
+}
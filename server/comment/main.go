@@ -13,9 +13,13 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"github.com/omsurase/blogger_microservices/server/comment/internal/handlers"
+	"github.com/omsurase/blogger_microservices/server/pkg/metrics"
+	"github.com/omsurase/blogger_microservices/server/pkg/identity"
 	"github.com/omsurase/blogger_microservices/server/comment/internal/publisher"
 	"github.com/omsurase/blogger_microservices/server/comment/internal/store"
+	"github.com/omsurase/blogger_microservices/server/pkg/tracing"
 )
 
 const (
@@ -25,12 +29,27 @@ const (
 	heartbeatDelay = 30 * time.Second
 )
 
+// scopeCommentsWrite is the API key scope that lets callers change comments.
+const scopeCommentsWrite = "comments:write"
+
 func main() {
+	metrics.Init(serviceName)
+
+	shutdownTracing, err := tracing.Init(context.Background(), serviceName)
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+
 	dbURL := os.Getenv("DATABASE_URL")
 	if dbURL == "" {
 		log.Fatal("DATABASE_URL environment variable is required")
 	}
 
+	identitySecret := os.Getenv("INTERNAL_IDENTITY_SECRET")
+	if identitySecret == "" {
+		log.Fatal("INTERNAL_IDENTITY_SECRET environment variable is required")
+	}
+
 	rabbitmqURL := os.Getenv("RABBITMQ_URL")
 	if rabbitmqURL == "" {
 		log.Fatal("RABBITMQ_URL environment variable is required")
@@ -45,6 +64,7 @@ func main() {
 	if err := store.InitDB(); err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
+	metrics.RegisterDB(store.DB(), "postgres")
 
 	log.Printf("Initializing RabbitMQ publisher with URL: %s", rabbitmqURL)
 	publisher, err := publisher.NewRabbitMQPublisher(rabbitmqURL)
@@ -56,6 +76,10 @@ func main() {
 
 	commentHandler := handlers.NewCommentHandler(store, publisher)
 	router := gin.Default()
+	router.Use(otelgin.Middleware(serviceName))
+	router.Use(metrics.Middleware())
+	router.Use(identity.NewVerifier([]byte(identitySecret), serviceName).Middleware())
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
 
 	// Group all routes under /comment prefix
 	commentGroup := router.Group("/comment")
@@ -66,9 +90,16 @@ func main() {
 				"timestamp": time.Now().UTC().Format(time.RFC3339Nano),
 			})
 		})
-		commentGroup.POST("/create", handlers.AuthMiddleware(), commentHandler.CreateComment)
+		commentGroup.POST("/create", handlers.AuthMiddleware(), identity.RequireScope(scopeCommentsWrite), commentHandler.CreateComment)
 		commentGroup.GET("/post/:postId", commentHandler.GetCommentsByPost)
-		commentGroup.DELETE("/:id", handlers.AuthMiddleware(), commentHandler.DeleteComment)
+		commentGroup.DELETE("/:id", handlers.AuthMiddleware(), identity.RequireScope(scopeCommentsWrite), commentHandler.DeleteComment)
+	}
+
+	// OpenAPI document of the routes above, merged into the gateway's docs
+	apiSpec := handlers.APISpec(os.Getenv("SERVICE_VERSION"))
+	router.GET("/comment/openapi.json", apiSpec.Handler())
+	if err := apiSpec.Check(router.Routes(), "/metrics", "/comment/health", "/comment/openapi.json"); err != nil {
+		log.Fatalf("API documentation is out of date: %v", err)
 	}
 
 	srv := &http.Server{
@@ -99,6 +130,10 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
+
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("Failed to flush traces: %v", err)
+	}
 }
 
 func registerService() error {
@@ -117,8 +152,10 @@ func registerService() error {
 
 	for i := 0; i < retryAttempts; i++ {
 		payload := map[string]string{
+			"id":      hostname,
 			"name":    serviceName,
 			"address": serviceAddress,
+			"version": os.Getenv("SERVICE_VERSION"),
 		}
 
 		jsonPayload, err := json.Marshal(payload)
@@ -146,10 +183,18 @@ func startHeartbeat() {
 	registryURL := os.Getenv("REGISTRY_URL")
 	heartbeatURL := fmt.Sprintf("%s/heartbeat", registryURL)
 
+	// The hostname doubles as the instance ID used at registration time.
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Printf("Failed to get hostname for heartbeat: %v", err)
+		return
+	}
+
 	ticker := time.NewTicker(heartbeatDelay)
 	go func() {
 		for range ticker.C {
 			payload := map[string]string{
+				"id":   hostname,
 				"name": serviceName,
 			}
 
@@ -1,15 +1,25 @@
 package consumer
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 
 	"github.com/omsurase/blogger_microservices/server/notification/internal/models"
+	"github.com/omsurase/blogger_microservices/server/pkg/metrics"
+	"github.com/omsurase/blogger_microservices/server/pkg/tracing"
+	"github.com/prometheus/client_golang/prometheus"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
-type MessageHandler func(*models.CommentEvent) error
+// MessageHandler processes an event. ctx carries the trace of the request
+// that published it.
+type MessageHandler func(context.Context, *models.CommentEvent) error
 
 type RabbitMQConsumer struct {
 	conn          *amqp.Connection
@@ -17,6 +27,8 @@ type RabbitMQConsumer struct {
 	exchangeName  string
 	queueName     string
 	handleMessage MessageHandler
+
+	consumed *prometheus.CounterVec
 }
 
 func NewRabbitMQConsumer(url string, handler MessageHandler) (*RabbitMQConsumer, error) {
@@ -37,6 +49,10 @@ func NewRabbitMQConsumer(url string, handler MessageHandler) (*RabbitMQConsumer,
 		exchangeName:  "events_exchange",
 		queueName:     "notification_queue",
 		handleMessage: handler,
+		consumed: metrics.NewCounterVec(prometheus.CounterOpts{
+			Name: "rabbitmq_messages_consumed_total",
+			Help: "RabbitMQ messages consumed, by queue and outcome.",
+		}, "queue", "outcome"),
 	}
 
 	// Setup exchange and queue
@@ -109,18 +125,7 @@ func (c *RabbitMQConsumer) Start() error {
 
 	go func() {
 		for msg := range msgs {
-			var event models.CommentEvent
-			if err := json.Unmarshal(msg.Body, &event); err != nil {
-				log.Printf("Error unmarshaling message: %v", err)
-				continue
-			}
-
-			log.Printf("Received message: %s", string(msg.Body))
-			log.Printf("Processing comment event: %+v", event)
-
-			if err := c.handleMessage(&event); err != nil {
-				log.Printf("Error processing message: %v", err)
-			}
+			c.process(msg)
 		}
 	}()
 
@@ -130,6 +135,43 @@ func (c *RabbitMQConsumer) Start() error {
 	return nil
 }
 
+// process handles a single message in a span that continues the publisher's
+// trace.
+func (c *RabbitMQConsumer) process(msg amqp.Delivery) {
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), tracing.HeaderCarrier(msg.Headers))
+	ctx, span := tracing.Tracer().Start(ctx, c.queueName+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemRabbitMQ,
+			semconv.MessagingOperationTypeProcess,
+			semconv.MessagingDestinationName(c.exchangeName),
+			semconv.MessagingConsumerGroupName(c.queueName),
+		),
+	)
+	defer span.End()
+
+	var event models.CommentEvent
+	if err := json.Unmarshal(msg.Body, &event); err != nil {
+		log.Printf("Error unmarshaling message: %v", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "malformed message")
+		c.consumed.WithLabelValues(c.queueName, "malformed").Inc()
+		return
+	}
+
+	log.Printf("Received message: %s", string(msg.Body))
+	log.Printf("Processing comment event: %+v", event)
+
+	if err := c.handleMessage(ctx, &event); err != nil {
+		log.Printf("Error processing message: %v", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		c.consumed.WithLabelValues(c.queueName, "error").Inc()
+		return
+	}
+	c.consumed.WithLabelValues(c.queueName, "success").Inc()
+}
+
 func (c *RabbitMQConsumer) Close() {
 	if c.channel != nil {
 		c.channel.Close()
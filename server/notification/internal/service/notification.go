@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,6 +12,10 @@ import (
 
 	"gopkg.in/mail.v2"
 	"github.com/omsurase/blogger_microservices/server/notification/internal/models"
+	"github.com/omsurase/blogger_microservices/server/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type NotificationService struct {
@@ -20,6 +25,7 @@ type NotificationService struct {
 	smtpPassword string
 	fromEmail    string
 	authServiceURL string
+	httpClient   *http.Client
 }
 
 func NewNotificationService() *NotificationService {
@@ -40,6 +46,7 @@ func NewNotificationService() *NotificationService {
 		smtpPassword: os.Getenv("SMTP_PASSWORD"),
 		fromEmail:    os.Getenv("FROM_EMAIL"),
 		authServiceURL: authServiceURL,
+		httpClient:   tracing.NewClient(),
 	}
 }
 
@@ -51,7 +58,7 @@ type UserResponse struct {
 	} `json:"data"`
 }
 
-func (s *NotificationService) getUserEmail(userID string) (string, error) {
+func (s *NotificationService) getUserEmail(ctx context.Context, userID string) (string, error) {
 	authServiceURL := os.Getenv("AUTH_SERVICE_URL")
 	if authServiceURL == "" {
 		authServiceURL = "http://auth-service:8080"
@@ -59,7 +66,11 @@ func (s *NotificationService) getUserEmail(userID string) (string, error) {
 
 	url := fmt.Sprintf("%s/auth/users/%s", authServiceURL, userID)
 	log.Printf("Fetching user details from: %s", url)
-	resp, err := http.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to build user request: %v", err)
+	}
+	resp, err := s.httpClient.Do(req)
 	if err != nil {
 		log.Printf("Failed to fetch user: %v", err)
 		return "", fmt.Errorf("failed to fetch user: %v", err)
@@ -89,11 +100,11 @@ func (s *NotificationService) getUserEmail(userID string) (string, error) {
 	return userResp.Data.Email, nil
 }
 
-func (s *NotificationService) CreateNotification(event *models.CommentEvent) (*models.EmailNotification, error) {
+func (s *NotificationService) CreateNotification(ctx context.Context, event *models.CommentEvent) (*models.EmailNotification, error) {
 	log.Printf("Creating notification for comment event: %+v", event)
 
 	// Get the author's email from the auth service
-	authorEmail, err := s.getUserEmail(event.AuthorID)
+	authorEmail, err := s.getUserEmail(ctx, event.AuthorID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get author's email: %v", err)
 	}
@@ -108,9 +119,18 @@ func (s *NotificationService) CreateNotification(event *models.CommentEvent) (*m
 	return notification, nil
 }
 
-func (s *NotificationService) SendNotification(notification *models.EmailNotification) error {
+func (s *NotificationService) SendNotification(ctx context.Context, notification *models.EmailNotification) error {
 	log.Printf("Attempting to send email via SMTP: %s:%d", s.smtpHost, s.smtpPort)
 
+	_, span := tracing.Tracer().Start(ctx, "smtp send",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("server.address", s.smtpHost),
+			attribute.Int("server.port", s.smtpPort),
+		),
+	)
+	defer span.End()
+
 	m := mail.NewMessage()
 	m.SetHeader("From", s.fromEmail)
 	m.SetHeader("To", notification.To)
@@ -120,6 +140,8 @@ func (s *NotificationService) SendNotification(notification *models.EmailNotific
 	d := mail.NewDialer(s.smtpHost, s.smtpPort, s.smtpUsername, s.smtpPassword)
 
 	if err := d.DialAndSend(m); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "send failed")
 		return fmt.Errorf("failed to send email: %v", err)
 	}
 
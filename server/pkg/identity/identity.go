@@ -0,0 +1,185 @@
+// Package identity verifies the signed identity assertions the API gateway
+// attaches to the requests it forwards.
+package identity
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Header carries the assertion.
+	Header = "X-Internal-Identity"
+	// UserIDHeader, UserEmailHeader and UserScopesHeader repeat the asserted
+	// identity for convenience; they are only trusted alongside a valid
+	// assertion.
+	UserIDHeader     = "X-User-ID"
+	UserEmailHeader  = "X-User-Email"
+	UserScopesHeader = "X-User-Scopes"
+
+	// clockSkew tolerates small clock differences between the gateway and
+	// the service.
+	clockSkew = 5 * time.Second
+)
+
+var (
+	ErrMalformed        = errors.New("malformed identity assertion")
+	ErrInvalidSignature = errors.New("invalid identity assertion signature")
+	ErrWrongAudience    = errors.New("identity assertion issued for another service")
+	ErrExpired          = errors.New("identity assertion expired")
+)
+
+// Claims is the identity asserted by the gateway. APIKey is set for callers
+// that authenticated with an API key, who may only use its Scopes.
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Email     string   `json:"email,omitempty"`
+	Scopes    []string `json:"scopes,omitempty"`
+	APIKey    string   `json:"api_key,omitempty"`
+	Audience  string   `json:"aud"`
+	IssuedAt  int64    `json:"iat"`
+	ExpiresAt int64    `json:"exp"`
+}
+
+// Allows reports whether the caller may act with scope. Callers with a
+// session may do anything.
+func (c *Claims) Allows(scope string) bool {
+	if c.APIKey == "" {
+		return true
+	}
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Verifier checks assertions issued for a single service.
+type Verifier struct {
+	secret   []byte
+	audience string
+}
+
+func NewVerifier(secret []byte, audience string) *Verifier {
+	return &Verifier{secret: secret, audience: audience}
+}
+
+// Verify checks the signature, audience and lifetime of an assertion of the
+// form base64url(claims) "." base64url(HMAC-SHA256(claims)).
+func (v *Verifier) Verify(assertion string) (*Claims, error) {
+	payload, sig, ok := strings.Cut(assertion, ".")
+	if !ok {
+		return nil, ErrMalformed
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	mac := hmac.New(sha256.New, v.secret)
+	mac.Write([]byte(payload))
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return nil, ErrInvalidSignature
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	var claims Claims
+	if err := json.Unmarshal(raw, &claims); err != nil || claims.Subject == "" {
+		return nil, ErrMalformed
+	}
+	if claims.Audience != v.audience {
+		return nil, ErrWrongAudience
+	}
+	now := time.Now()
+	if now.After(time.Unix(claims.ExpiresAt, 0).Add(clockSkew)) ||
+		now.Before(time.Unix(claims.IssuedAt, 0).Add(-clockSkew)) {
+		return nil, ErrExpired
+	}
+	return &claims, nil
+}
+
+// Middleware establishes the caller's identity for every request. A valid
+// assertion sets "user_id", "user_email" and "identity" in the context;
+// requests without one are anonymous. Requests carrying an invalid
+// assertion, or identity headers without an assertion, are rejected.
+func (v *Verifier) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		assertion := c.GetHeader(Header)
+		if assertion == "" {
+			if c.GetHeader(UserIDHeader) != "" || c.GetHeader(UserEmailHeader) != "" || c.GetHeader(UserScopesHeader) != "" {
+				abort(c, "Unsigned identity headers", nil)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		claims, err := v.Verify(assertion)
+		if err != nil {
+			abort(c, "Invalid identity assertion", err)
+			return
+		}
+
+		// Handlers that read the headers see the asserted identity only
+		c.Request.Header.Set(UserIDHeader, claims.Subject)
+		c.Request.Header.Del(UserEmailHeader)
+		if claims.Email != "" {
+			c.Request.Header.Set(UserEmailHeader, claims.Email)
+		}
+		c.Request.Header.Del(UserScopesHeader)
+		if claims.APIKey != "" {
+			c.Request.Header.Set(UserScopesHeader, strings.Join(claims.Scopes, " "))
+		}
+		c.Set("user_id", claims.Subject)
+		c.Set("user_email", claims.Email)
+		c.Set("identity", claims)
+		c.Next()
+	}
+}
+
+// RequireScope rejects callers that authenticated with an API key lacking
+// scope, one of the scopes the auth service lets keys grant. Callers without
+// an identity and callers that did not use an API key pass through, so it
+// also suits routes open to anonymous callers.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if claims, ok := c.Get("identity"); ok && !claims.(*Claims).Allows(scope) {
+			reject(c, http.StatusForbidden, "API key lacks the required scope", errors.New("missing scope "+scope))
+			return
+		}
+		c.Next()
+	}
+}
+
+func abort(c *gin.Context, message string, err error) {
+	reject(c, http.StatusUnauthorized, message, err)
+}
+
+// errorResponse has the shape of the services' error responses.
+type errorResponse struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+	Error   string `json:"error,omitempty"`
+}
+
+func reject(c *gin.Context, status int, message string, err error) {
+	errResponse := errorResponse{
+		Status:  status,
+		Message: message,
+	}
+	if err != nil {
+		errResponse.Error = err.Error()
+	}
+	c.AbortWithStatusJSON(status, errResponse)
+}
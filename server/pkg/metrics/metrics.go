@@ -0,0 +1,145 @@
+// Package metrics exposes Prometheus metrics for a service.
+//
+// Every metric carries a "service" label, and HTTP metrics are labelled by
+// route, method and status, so that one dashboard covers the whole fleet.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	registerer prometheus.Registerer = prometheus.DefaultRegisterer
+
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+)
+
+// Init registers the service's metrics under serviceName. It must be called
+// before any other function of the package.
+func Init(serviceName string) {
+	registerer = prometheus.WrapRegistererWith(prometheus.Labels{"service": serviceName}, prometheus.DefaultRegisterer)
+
+	httpRequests = promauto.With(registerer).NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests handled, by route, method and status.",
+	}, []string{"route", "method", "status"})
+	httpDuration = promauto.With(registerer).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency, by route, method and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+}
+
+// NewCounterVec registers a counter of the service's own, labelled like the
+// others with the service name.
+func NewCounterVec(opts prometheus.CounterOpts, labels ...string) *prometheus.CounterVec {
+	return promauto.With(registerer).NewCounterVec(opts, labels)
+}
+
+// Middleware records the count and latency of every request. Requests that
+// match no route are labelled "unmatched" to keep label cardinality bounded.
+func Middleware() gin.HandlerFunc {
+	return MiddlewareWithRoute(func(c *gin.Context) string {
+		if route := c.FullPath(); route != "" {
+			return route
+		}
+		return "unmatched"
+	})
+}
+
+// MiddlewareWithRoute is Middleware for routers whose requests are labelled
+// by route rather than by the path they matched.
+func MiddlewareWithRoute(route func(*gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		label := route(c)
+		status := strconv.Itoa(c.Writer.Status())
+		httpRequests.WithLabelValues(label, c.Request.Method, status).Inc()
+		httpDuration.WithLabelValues(label, c.Request.Method, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// RegisterDB exports the connection pool statistics of db.
+func RegisterDB(db *sql.DB, name string) {
+	registerer.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// RedisPoolStats are the connection pool statistics of a Redis client. The
+// PoolStats of every go-redis version convert to it.
+type RedisPoolStats struct {
+	Hits     uint32
+	Misses   uint32
+	Timeouts uint32
+
+	TotalConns uint32
+	IdleConns  uint32
+	StaleConns uint32
+}
+
+// RegisterRedis exports the connection pool statistics that stats returns.
+func RegisterRedis(stats func() RedisPoolStats, name string) {
+	registerer.MustRegister(newRedisPoolCollector(stats, name))
+}
+
+type redisPoolCollector struct {
+	stats func() RedisPoolStats
+
+	hits       *prometheus.Desc
+	misses     *prometheus.Desc
+	timeouts   *prometheus.Desc
+	totalConns *prometheus.Desc
+	idleConns  *prometheus.Desc
+	staleConns *prometheus.Desc
+}
+
+func newRedisPoolCollector(stats func() RedisPoolStats, name string) *redisPoolCollector {
+	labels := prometheus.Labels{"pool": name}
+	desc := func(metric, help string) *prometheus.Desc {
+		return prometheus.NewDesc("redis_pool_"+metric, help, nil, labels)
+	}
+	return &redisPoolCollector{
+		stats:      stats,
+		hits:       desc("hits_total", "Times a free connection was found in the pool."),
+		misses:     desc("misses_total", "Times a free connection was not found in the pool."),
+		timeouts:   desc("timeouts_total", "Times a wait for a connection timed out."),
+		totalConns: desc("connections", "Connections in the pool."),
+		idleConns:  desc("idle_connections", "Idle connections in the pool."),
+		staleConns: desc("stale_connections_total", "Stale connections removed from the pool."),
+	}
+}
+
+func (c *redisPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.timeouts
+	ch <- c.totalConns
+	ch <- c.idleConns
+	ch <- c.staleConns
+}
+
+func (c *redisPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.stats()
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(s.Hits))
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(s.Misses))
+	ch <- prometheus.MustNewConstMetric(c.timeouts, prometheus.CounterValue, float64(s.Timeouts))
+	ch <- prometheus.MustNewConstMetric(c.totalConns, prometheus.GaugeValue, float64(s.TotalConns))
+	ch <- prometheus.MustNewConstMetric(c.idleConns, prometheus.GaugeValue, float64(s.IdleConns))
+	ch <- prometheus.MustNewConstMetric(c.staleConns, prometheus.CounterValue, float64(s.StaleConns))
+}
@@ -0,0 +1,417 @@
+// Package openapi describes a service's HTTP API as an OpenAPI 3 document.
+// Schemas are derived by reflection from the models the handlers bind and
+// return, and Check compares the documented operations with the routes
+// registered on the router, so the document cannot drift from the code.
+package openapi
+
+import (
+	"encoding"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Operation documents one route.
+type Operation struct {
+	Method   string // as registered on the router
+	Path     string // as registered on the router, e.g. /post/:id
+	Summary  string
+	Auth     bool    // requires an authenticated caller
+	Query    []Param // query parameters
+	Request  any     // model of the JSON request body; nil when there is none
+	Response any     // model of the data of a success response; nil when there is none
+	Status   int     // status of a success response; 200 when zero
+	Errors   []int   // statuses of error responses
+}
+
+// Param documents a query parameter.
+type Param struct {
+	Name        string
+	Type        string // JSON schema type; "string" when empty
+	Description string
+}
+
+// Message is the data of responses that only carry a message.
+type Message struct {
+	Message string `json:"message"`
+}
+
+// Spec collects the operations of a service. Success responses wrap their
+// data in the envelope model's "data" field, and error responses are the
+// error model.
+type Spec struct {
+	title    string
+	version  string
+	envelope any
+	errModel any
+	ops      []Operation
+}
+
+// New returns an empty Spec. version defaults to "unversioned".
+func New(title, version string, envelope, errModel any) *Spec {
+	if version == "" {
+		version = "unversioned"
+	}
+	return &Spec{title: title, version: version, envelope: envelope, errModel: errModel}
+}
+
+// Add documents ops.
+func (s *Spec) Add(ops ...Operation) {
+	s.ops = append(s.ops, ops...)
+}
+
+// Check reports routes that are registered but not documented, except those
+// under the undocumented paths, and operations that are documented but not
+// registered.
+func (s *Spec) Check(routes gin.RoutesInfo, undocumented ...string) error {
+	documented := make(map[string]bool, len(s.ops))
+	for _, op := range s.ops {
+		documented[op.Method+" "+op.Path] = true
+	}
+
+	var problems []string
+	registered := make(map[string]bool, len(routes))
+	for _, r := range routes {
+		key := r.Method + " " + r.Path
+		registered[key] = true
+		if !documented[key] && !containsPath(undocumented, r.Path) {
+			problems = append(problems, "route "+key+" is not documented")
+		}
+	}
+	for _, op := range s.ops {
+		if key := op.Method + " " + op.Path; !registered[key] {
+			problems = append(problems, "operation "+key+" is not registered")
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("openapi: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func containsPath(paths []string, path string) bool {
+	for _, p := range paths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler serves the document as JSON.
+func (s *Spec) Handler() gin.HandlerFunc {
+	doc := s.Document()
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, doc)
+	}
+}
+
+// Document is an OpenAPI 3 document, limited to what the services use.
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// PathItem holds the operations on a path by lower-case method.
+type PathItem map[string]*DocOperation
+
+type DocOperation struct {
+	Summary     string                `json:"summary,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Required    bool    `json:"required,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Minimum              *int               `json:"minimum,omitempty"`
+	Maximum              *int               `json:"maximum,omitempty"`
+}
+
+const (
+	jsonContent    = "application/json"
+	bearerAuthName = "bearerAuth"
+)
+
+// Document builds the OpenAPI document of the operations added so far.
+func (s *Spec) Document() *Document {
+	doc := &Document{
+		OpenAPI: "3.0.3",
+		Info:    Info{Title: s.title, Version: s.version},
+		Paths:   make(map[string]*PathItem),
+		Components: Components{
+			Schemas: make(map[string]*Schema),
+		},
+	}
+	gen := &schemaGenerator{schemas: doc.Components.Schemas}
+	envelope := gen.schema(reflect.TypeOf(s.envelope))
+	errSchema := gen.schema(reflect.TypeOf(s.errModel))
+
+	for _, op := range s.ops {
+		path, params := openAPIPath(op.Path)
+		item := doc.Paths[path]
+		if item == nil {
+			item = &PathItem{}
+			doc.Paths[path] = item
+		}
+
+		dop := &DocOperation{Summary: op.Summary, Parameters: params, Responses: make(map[string]Response)}
+		for _, q := range op.Query {
+			typ := q.Type
+			if typ == "" {
+				typ = "string"
+			}
+			dop.Parameters = append(dop.Parameters, Parameter{Name: q.Name, In: "query", Description: q.Description, Schema: &Schema{Type: typ}})
+		}
+		if op.Request != nil {
+			dop.RequestBody = &RequestBody{
+				Required: true,
+				Content:  map[string]MediaType{jsonContent: {Schema: gen.schema(reflect.TypeOf(op.Request))}},
+			}
+		}
+
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := envelope
+		if op.Response != nil {
+			success = &Schema{AllOf: []*Schema{envelope, {
+				Type:       "object",
+				Properties: map[string]*Schema{"data": gen.schema(reflect.TypeOf(op.Response))},
+			}}}
+		}
+		dop.Responses[strconv.Itoa(status)] = Response{
+			Description: http.StatusText(status),
+			Content:     map[string]MediaType{jsonContent: {Schema: success}},
+		}
+		for _, code := range op.Errors {
+			dop.Responses[strconv.Itoa(code)] = Response{
+				Description: http.StatusText(code),
+				Content:     map[string]MediaType{jsonContent: {Schema: errSchema}},
+			}
+		}
+
+		if op.Auth {
+			dop.Security = []map[string][]string{{bearerAuthName: {}}}
+			doc.Components.SecuritySchemes = map[string]SecurityScheme{
+				bearerAuthName: {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+			}
+		}
+		(*item)[strings.ToLower(op.Method)] = dop
+	}
+	return doc
+}
+
+// openAPIPath converts a router path to OpenAPI's template syntax and
+// returns its parameters.
+func openAPIPath(path string) (string, []Parameter) {
+	var params []Parameter
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		if len(seg) > 1 && (seg[0] == ':' || seg[0] == '*') {
+			name := seg[1:]
+			segments[i] = "{" + name + "}"
+			params = append(params, Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// schemaGenerator derives schemas from Go types the way encoding/json
+// marshals them. Named structs become components, referenced by name.
+type schemaGenerator struct {
+	schemas map[string]*Schema
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t.PkgPath() == "github.com/google/uuid" && t.Name() == "UUID":
+		return &Schema{Type: "string", Format: "uuid"}
+	case t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType):
+		return &Schema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		if _, ok := g.schemas[t.Name()]; !ok {
+			// Reserve the name first, so that recursive types terminate
+			g.schemas[t.Name()] = &Schema{}
+			*g.schemas[t.Name()] = *g.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	}
+	// Interfaces hold any value
+	return &Schema{}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	g.addFields(s, t)
+	sort.Strings(s.Required)
+	return s
+}
+
+func (g *schemaGenerator) addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.addFields(s, ft)
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		prop := g.schema(f.Type)
+		// Rules after "dive" apply to the elements of an array
+		target := prop
+		for _, rule := range strings.Split(f.Tag.Get("binding"), ",") {
+			key, value, _ := strings.Cut(rule, "=")
+			switch key {
+			case "required":
+				if target == prop {
+					s.Required = append(s.Required, name)
+				}
+			case "dive":
+				if target.Items == nil {
+					break
+				}
+				target = target.Items
+			case "email":
+				target.Format = "email"
+			case "oneof":
+				target.Enum = strings.Fields(value)
+			case "min", "max":
+				if n, err := strconv.Atoi(value); err == nil {
+					setBound(target, key == "min", n)
+				}
+			}
+		}
+		s.Properties[name] = prop
+	}
+}
+
+// setBound applies a min or max binding rule, which limits the length of a
+// string, the length of an array or the value of a number.
+func setBound(s *Schema, isMin bool, n int) {
+	var lower, upper **int
+	switch s.Type {
+	case "string":
+		lower, upper = &s.MinLength, &s.MaxLength
+	case "array":
+		lower, upper = &s.MinItems, &s.MaxItems
+	case "integer", "number":
+		lower, upper = &s.Minimum, &s.Maximum
+	default:
+		return
+	}
+	if isMin {
+		*lower = &n
+	} else {
+		*upper = &n
+	}
+}
@@ -0,0 +1,130 @@
+// Package tracing configures OpenTelemetry tracing for a service and
+// propagates trace context over HTTP and AMQP.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName names the tracer of the service Init was called for.
+var tracerName = "github.com/omsurase/blogger_microservices/server/pkg/tracing"
+
+// Init installs the global tracer provider and W3C trace context propagator.
+//
+// Spans are exported to the file named by OTEL_TRACES_FILE, one JSON document
+// per span, or otherwise over OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set. Without either, spans are not
+// exported but trace context is still propagated. Sampling follows the
+// standard OTEL_TRACES_SAMPLER variables.
+//
+// Outgoing requests carry trace context when they are sent with a client from
+// NewClient.
+//
+// The returned function flushes pending spans and must be called on exit.
+func Init(ctx context.Context, serviceName string) (func(context.Context) error, error) {
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build trace resource: %v", err)
+	}
+
+	opts := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+	var file *os.File
+	switch {
+	case os.Getenv("OTEL_TRACES_FILE") != "":
+		file, err = os.OpenFile(os.Getenv("OTEL_TRACES_FILE"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open trace file: %v", err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to create file exporter: %v", err)
+		}
+		// Synchronous export keeps the file complete even if the process
+		// is killed
+		opts = append(opts, sdktrace.WithSyncer(exporter))
+	case os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "":
+		exporter, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OTLP exporter: %v", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+
+	tp := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	tracerName = serviceName
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if file != nil {
+			file.Close()
+		}
+		return err
+	}, nil
+}
+
+// Tracer returns the service's tracer for spans that are not created by
+// instrumentation libraries.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// NewTransport instruments base, so that requests made with the context of a
+// traced request carry its trace context and get a client span.
+func NewTransport(base http.RoundTripper) http.RoundTripper {
+	// Requests outside any trace, such as background calls, would only add
+	// noise
+	return otelhttp.NewTransport(base,
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			return trace.SpanContextFromContext(r.Context()).IsValid()
+		}),
+	)
+}
+
+// NewClient returns an HTTP client whose requests carry trace context; see
+// NewTransport.
+func NewClient() *http.Client {
+	return &http.Client{Transport: NewTransport(http.DefaultTransport)}
+}
+
+// HeaderCarrier carries trace context in AMQP message headers. Convert an
+// amqp.Table to it to inject or extract the context.
+type HeaderCarrier map[string]any
+
+func (h HeaderCarrier) Get(key string) string {
+	v, _ := h[key].(string)
+	return v
+}
+
+func (h HeaderCarrier) Set(key, value string) {
+	h[key] = value
+}
+
+func (h HeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"log"
 	"net/http"
 	"strconv"
 	"time"
@@ -44,6 +45,22 @@ func sendSuccess(c *gin.Context, status int, data interface{}) {
 	})
 }
 
+// Surrogate keys tag cacheable responses so that the gateway can purge them:
+// every list of posts, and each single post.
+const surrogateKeyPosts = "posts"
+
+func surrogateKeyPost(id uuid.UUID) string {
+	return "post:" + id.String()
+}
+
+// purgeGatewayCache drops the gateway's cached responses for the keys. A
+// failed purge only leaves responses cached until they expire.
+func (h *Handler) purgeGatewayCache(c *gin.Context, keys ...string) {
+	if err := h.redisStore.PurgeGatewayCache(c.Request.Context(), keys...); err != nil {
+		log.Printf("Failed to purge gateway cache for %v: %v", keys, err)
+	}
+}
+
 func getUserIDFromToken(c *gin.Context) (uuid.UUID, error) {
 	claims := c.MustGet("claims").(jwt.MapClaims)
 	userID, err := uuid.Parse(claims["id"].(string))
@@ -92,6 +109,7 @@ func (h *Handler) CreatePost(c *gin.Context) {
 		return
 	}
 
+	h.purgeGatewayCache(c, surrogateKeyPosts)
 	sendSuccess(c, http.StatusCreated, post)
 }
 
@@ -114,6 +132,8 @@ func (h *Handler) GetPost(c *gin.Context) {
 		return
 	}
 
+	c.Header("Cache-Control", "public, max-age=60")
+	c.Header("Surrogate-Key", surrogateKeyPost(post.ID))
 	sendSuccess(c, http.StatusOK, post)
 }
 
@@ -169,6 +189,7 @@ func (h *Handler) UpdatePost(c *gin.Context) {
 		return
 	}
 
+	h.purgeGatewayCache(c, surrogateKeyPost(post.ID), surrogateKeyPosts)
 	sendSuccess(c, http.StatusOK, post)
 }
 
@@ -206,6 +227,7 @@ func (h *Handler) DeletePost(c *gin.Context) {
 		return
 	}
 
+	h.purgeGatewayCache(c, surrogateKeyPost(postID), surrogateKeyPosts)
 	sendSuccess(c, http.StatusOK, gin.H{"message": "Post deleted successfully"})
 }
 
@@ -246,6 +268,7 @@ func (h *Handler) GetPostsByUser(c *gin.Context) {
 	}
 
 	c.Header("Cache-Control", "public, max-age=60")
+	c.Header("Surrogate-Key", surrogateKeyPosts)
 	sendSuccess(c, http.StatusOK, response)
 }
 
@@ -286,6 +309,7 @@ func (h *Handler) GetPostsByTag(c *gin.Context) {
 	}
 
 	c.Header("Cache-Control", "public, max-age=60")
+	c.Header("Surrogate-Key", surrogateKeyPosts)
 	sendSuccess(c, http.StatusOK, response)
 }
 
@@ -320,5 +344,6 @@ func (h *Handler) GetAllPosts(c *gin.Context) {
 	}
 
 	c.Header("Cache-Control", "public, max-age=60")
+	c.Header("Surrogate-Key", surrogateKeyPosts)
 	sendSuccess(c, http.StatusOK, response)
 } 
\ No newline at end of file
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/omsurase/blogger_microservices/server/pkg/openapi"
+	"github.com/omsurase/blogger_microservices/server/post/internal/models"
+)
+
+var pageParams = []openapi.Param{
+	{Name: "page", Type: "integer", Description: "Page number, from 1 (default 1)"},
+	{Name: "page_size", Type: "integer", Description: "Posts per page, from 1 to 100 (default 10)"},
+}
+
+// APISpec documents the routes main registers; main checks that the two
+// agree.
+func APISpec(version string) *openapi.Spec {
+	spec := openapi.New("Post Service", version, models.SuccessResponse{}, models.ErrorResponse{})
+	spec.Add(
+		openapi.Operation{
+			Method: http.MethodPost, Path: "/post/create", Summary: "Create a post", Auth: true,
+			Request: models.CreatePostRequest{}, Response: models.Post{}, Status: http.StatusCreated,
+			Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusUnsupportedMediaType, http.StatusInternalServerError},
+		},
+		openapi.Operation{
+			Method: http.MethodPut, Path: "/post/:id", Summary: "Update a post of the caller", Auth: true,
+			Request: models.UpdatePostRequest{}, Response: models.Post{},
+			Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusInternalServerError},
+		},
+		openapi.Operation{
+			Method: http.MethodDelete, Path: "/post/:id", Summary: "Delete a post of the caller", Auth: true,
+			Response: openapi.Message{},
+			Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusInternalServerError},
+		},
+		openapi.Operation{
+			Method: http.MethodGet, Path: "/post/:id", Summary: "Get a post",
+			Response: models.Post{},
+			Errors:   []int{http.StatusBadRequest, http.StatusNotFound},
+		},
+		openapi.Operation{
+			Method: http.MethodGet, Path: "/post/user/:id", Summary: "List the posts of a user",
+			Query: pageParams, Response: models.PaginatedPostsResponse{},
+			Errors: []int{http.StatusBadRequest, http.StatusInternalServerError},
+		},
+		openapi.Operation{
+			Method: http.MethodGet, Path: "/post/tag/:tag", Summary: "List the posts with a tag",
+			Query: pageParams, Response: models.PaginatedPostsResponse{},
+			Errors: []int{http.StatusBadRequest, http.StatusInternalServerError},
+		},
+		openapi.Operation{
+			Method: http.MethodGet, Path: "/post", Summary: "List all posts",
+			Query: pageParams, Response: models.PaginatedPostsResponse{},
+			Errors: []int{http.StatusBadRequest, http.StatusInternalServerError},
+		},
+	)
+	return spec
+}
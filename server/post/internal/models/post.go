@@ -17,14 +17,14 @@ type Post struct {
 }
 
 type CreatePostRequest struct {
-	Title   string   `json:"title" binding:"required"`
-	Content string   `json:"content" binding:"required"`
+	Title   string   `json:"title" binding:"required,max=200"`
+	Content string   `json:"content" binding:"required,max=100000"`
 	Tags    []string `json:"tags"`
 }
 
 type UpdatePostRequest struct {
-	Title   string   `json:"title"`
-	Content string   `json:"content"`
+	Title   string   `json:"title" binding:"max=200"`
+	Content string   `json:"content" binding:"max=100000"`
 	Tags    []string `json:"tags"`
 }
 
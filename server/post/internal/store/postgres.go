@@ -263,4 +263,9 @@ func (s *PostgresStore) GetAllPosts(page, pageSize int) ([]models.Post, int, err
 	}
 
 	return posts, totalCount, rows.Err()
-} 
\ No newline at end of file
+}
+
+// DB returns the underlying connection pool, for metrics.
+func (s *PostgresStore) DB() *sql.DB {
+	return s.db
+}
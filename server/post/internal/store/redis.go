@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -61,4 +62,41 @@ func (s *RedisStore) Get(ctx context.Context, id uuid.UUID) (*models.Post, error
 func (s *RedisStore) Delete(ctx context.Context, id uuid.UUID) error {
 	key := fmt.Sprintf("post:%s", id.String())
 	return s.client.Del(ctx, key).Err()
-} 
\ No newline at end of file
+}
+
+// gatewayCachePurgeChannel is where the API gateways listen for purges of
+// their response cache.
+const gatewayCachePurgeChannel = "gateway:cache:purge"
+
+// gatewayCachePurgeScript deletes the gateway's cached responses tagged with
+// any of the tag sets in KEYS, and the sets. Members are deleted in batches,
+// to stay within the number of arguments Lua unpacks.
+var gatewayCachePurgeScript = redis.NewScript(`
+for _, tag in ipairs(KEYS) do
+  local keys = redis.call('SMEMBERS', tag)
+  for i = 1, #keys, 500 do
+    redis.call('DEL', unpack(keys, i, math.min(i + 499, #keys)))
+  end
+  redis.call('DEL', tag)
+end
+return 0
+`)
+
+// PurgeGatewayCache deletes the API gateways' cached responses tagged with
+// any of the surrogate keys from Redis, then asks the gateways to drop their
+// in-memory copies.
+func (s *RedisStore) PurgeGatewayCache(ctx context.Context, keys ...string) error {
+	tags := make([]string, len(keys))
+	for i, key := range keys {
+		tags[i] = "gwcache:tag:" + key
+	}
+	if err := gatewayCachePurgeScript.Run(ctx, s.client, tags).Err(); err != nil {
+		return err
+	}
+	return s.client.Publish(ctx, gatewayCachePurgeChannel, strings.Join(keys, " ")).Err()
+}
+
+// PoolStats returns the connection pool statistics of the Redis client.
+func (s *RedisStore) PoolStats() *redis.PoolStats {
+	return s.client.PoolStats()
+}
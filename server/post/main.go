@@ -13,8 +13,12 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"github.com/omsurase/blogger_microservices/server/post/internal/handlers"
+	"github.com/omsurase/blogger_microservices/server/pkg/metrics"
+	"github.com/omsurase/blogger_microservices/server/pkg/identity"
 	"github.com/omsurase/blogger_microservices/server/post/internal/store"
+	"github.com/omsurase/blogger_microservices/server/pkg/tracing"
 )
 
 const (
@@ -24,16 +28,19 @@ const (
 	heartbeatDelay = 30 * time.Second
 )
 
+// scopePostsWrite is the API key scope that lets callers change posts.
+const scopePostsWrite = "posts:write"
+
+// authMiddleware requires the caller identity established by the identity
+// middleware from the gateway's signed assertion.
 func authMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userID := c.GetHeader("X-User-ID")
-		if userID == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "X-User-ID header missing"})
+		if c.GetString("user_id") == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
 			c.Abort()
 			return
 		}
 
-		c.Set("user_id", userID)
 		c.Next()
 	}
 }
@@ -54,8 +61,10 @@ func registerService() error {
 
 	for i := 0; i < retryAttempts; i++ {
 		payload := map[string]string{
+			"id":      hostname,
 			"name":    serviceName,
 			"address": serviceAddress,
+			"version": os.Getenv("SERVICE_VERSION"),
 		}
 
 		jsonPayload, err := json.Marshal(payload)
@@ -83,10 +92,18 @@ func startHeartbeat() {
 	registryURL := os.Getenv("REGISTRY_URL")
 	heartbeatURL := fmt.Sprintf("%s/heartbeat", registryURL)
 
+	// The hostname doubles as the instance ID used at registration time.
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Printf("Failed to get hostname for heartbeat: %v", err)
+		return
+	}
+
 	ticker := time.NewTicker(heartbeatDelay)
 	go func() {
 		for range ticker.C {
 			payload := map[string]string{
+				"id":   hostname,
 				"name": serviceName,
 			}
 
@@ -112,6 +129,18 @@ func main() {
 		port = "8080"
 	}
 
+	identitySecret := os.Getenv("INTERNAL_IDENTITY_SECRET")
+	if identitySecret == "" {
+		log.Fatal("INTERNAL_IDENTITY_SECRET environment variable is required")
+	}
+
+	metrics.Init(serviceName)
+
+	shutdownTracing, err := tracing.Init(context.Background(), serviceName)
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+
 	pgStore, err := store.NewPostgresStore(os.Getenv("DATABASE_URL"))
 	if err != nil {
 		log.Fatalf("Failed to connect to PostgreSQL: %v", err)
@@ -121,13 +150,19 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to connect to Redis: %v", err)
 	}
+	metrics.RegisterDB(pgStore.DB(), "postgres")
+	metrics.RegisterRedis(func() metrics.RedisPoolStats { return metrics.RedisPoolStats(*redisStore.PoolStats()) }, "redis")
 
 	handler := handlers.NewHandler(pgStore, redisStore)
 	router := gin.Default()
-
-	router.POST("/post/create", authMiddleware(), handler.CreatePost)
-	router.PUT("/post/:id", authMiddleware(), handler.UpdatePost)
-	router.DELETE("/post/:id", authMiddleware(), handler.DeletePost)
+	router.Use(otelgin.Middleware(serviceName))
+	router.Use(metrics.Middleware())
+	router.Use(identity.NewVerifier([]byte(identitySecret), serviceName).Middleware())
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+	router.POST("/post/create", authMiddleware(), identity.RequireScope(scopePostsWrite), handler.CreatePost)
+	router.PUT("/post/:id", authMiddleware(), identity.RequireScope(scopePostsWrite), handler.UpdatePost)
+	router.DELETE("/post/:id", authMiddleware(), identity.RequireScope(scopePostsWrite), handler.DeletePost)
 	router.GET("/post/:id", handler.GetPost)
 	router.GET("/post/user/:id", handler.GetPostsByUser)
 	router.GET("/post/tag/:tag", handler.GetPostsByTag)
@@ -141,6 +176,13 @@ func main() {
 		})
 	})
 
+	// OpenAPI document of the routes above, merged into the gateway's docs
+	apiSpec := handlers.APISpec(os.Getenv("SERVICE_VERSION"))
+	router.GET("/post/openapi.json", apiSpec.Handler())
+	if err := apiSpec.Check(router.Routes(), "/metrics", "/post/health", "/post/openapi.json"); err != nil {
+		log.Fatalf("API documentation is out of date: %v", err)
+	}
+
 	srv := &http.Server{
 		Addr:    fmt.Sprintf(":%s", port),
 		Handler: router,
@@ -170,4 +212,8 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
+
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("Failed to flush traces: %v", err)
+	}
 } 
\ No newline at end of file
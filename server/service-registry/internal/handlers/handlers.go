@@ -8,7 +8,6 @@ import (
 	"github.com/blogging-platform/service-registry/internal/store"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
-	"errors"
 )
 
 type Handler struct {
@@ -56,29 +55,33 @@ func (h *Handler) RegisterService(c *gin.Context) {
 		return
 	}
 
+	// Instances that do not identify themselves are treated as the single
+	// instance of their service, which keeps older clients working.
+	instanceID := strings.TrimSpace(req.ID)
+	if instanceID == "" {
+		instanceID = req.Name
+	}
+
 	service := &models.Service{
+		ID:      instanceID,
 		Name:    req.Name,
 		Address: req.Address,
+		Version: strings.TrimSpace(req.Version),
 	}
 
-	if err := h.store.RegisterService(c.Request.Context(), service); err != nil {
+	replaced, err := h.store.RegisterService(c.Request.Context(), service)
+	if err != nil {
 		h.logger.WithError(err).Error("Failed to register service")
-
-		// If the service already exists, refresh the TTL and treat it as a successful registration.
-		if errors.Is(err, store.ErrServiceExists) {
-			if ttlErr := h.store.UpdateTTL(c.Request.Context(), service.Name); ttlErr != nil {
-				h.logger.WithError(ttlErr).Error("Failed to refresh TTL for existing service")
-				sendError(c, http.StatusInternalServerError, "Failed to refresh TTL for existing service", ttlErr)
-				return
-			}
-			sendSuccess(c, http.StatusOK, map[string]string{"name": service.Name, "status": "already registered"})
-			return
-		}
-
 		sendError(c, http.StatusInternalServerError, "Failed to register service", err)
 		return
 	}
 
+	// An instance registering again, e.g. after a restart, replaces its
+	// record, so that a new version or address takes effect at once
+	if replaced {
+		sendSuccess(c, http.StatusOK, service)
+		return
+	}
 	sendSuccess(c, http.StatusCreated, service)
 }
 
@@ -106,7 +109,12 @@ func (h *Handler) Heartbeat(c *gin.Context) {
 		return
 	}
 
-	if err := h.store.UpdateTTL(c.Request.Context(), req.Name); err != nil {
+	instanceID := strings.TrimSpace(req.ID)
+	if instanceID == "" {
+		instanceID = req.Name
+	}
+
+	if err := h.store.UpdateTTL(c.Request.Context(), req.Name, instanceID); err != nil {
 		h.logger.WithError(err).Error("Failed to update service TTL")
 		
 		switch err {
@@ -118,7 +126,7 @@ func (h *Handler) Heartbeat(c *gin.Context) {
 		return
 	}
 
-	sendSuccess(c, http.StatusOK, map[string]string{"name": req.Name})
+	sendSuccess(c, http.StatusOK, map[string]string{"id": instanceID, "name": req.Name})
 } 
 
 // Health returns the health status of the service registry and Redis connection
@@ -1,17 +1,25 @@
 package models
 
+// Service is a single registered instance of a named service. Several
+// instances may share a Name; each is identified by its own ID. Version tags
+// the release the instance runs, so that gateways can route to it by version.
 type Service struct {
+	ID      string `json:"id"`
 	Name    string `json:"name"`
 	Address string `json:"address"`
+	Version string `json:"version,omitempty"`
 }
 
 type HeartbeatRequest struct {
+	ID   string `json:"id"`
 	Name string `json:"name"`
 }
 
 type RegisterRequest struct {
+	ID      string `json:"id"`
 	Name    string `json:"name"`
 	Address string `json:"address"`
+	Version string `json:"version"`
 }
 
 type ErrorResponse struct {
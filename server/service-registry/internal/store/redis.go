@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/blogging-platform/service-registry/internal/models"
@@ -12,11 +13,11 @@ import (
 
 const (
 	serviceTTL = 60 * time.Second
+	keyPrefix  = "service:"
 )
 
 var (
 	ErrServiceNotFound = errors.New("service not found")
-	ErrServiceExists = errors.New("service already exists")
 	ErrInvalidService = errors.New("invalid service data")
 )
 
@@ -39,33 +40,40 @@ func NewRedisStore(addr string) (*RedisStore, error) {
 	}, nil
 }
 
-func (s *RedisStore) RegisterService(ctx context.Context, service *models.Service) error {
-	if service == nil || service.Name == "" || service.Address == "" {
-		return ErrInvalidService
-	}
+// instanceKey returns the Redis key of a single service instance. Instances are
+// namespaced so that the registry can share a Redis database with other data.
+func instanceKey(name, id string) string {
+	return fmt.Sprintf("%s%s:%s", keyPrefix, name, id)
+}
 
-	exists, err := s.client.Exists(ctx, service.Name).Result()
-	if err != nil {
-		return err
-	}
-	if exists == 1 {
-		return ErrServiceExists
+// RegisterService stores the instance, replacing the record of an instance
+// registered under the same ID, such as one that restarted with a new version
+// or address. replaced reports whether there was such a record.
+func (s *RedisStore) RegisterService(ctx context.Context, service *models.Service) (replaced bool, err error) {
+	if service == nil || service.ID == "" || service.Name == "" || service.Address == "" {
+		return false, ErrInvalidService
 	}
 
 	data, err := json.Marshal(service)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	return s.client.Set(ctx, service.Name, data, serviceTTL).Err()
+	key := instanceKey(service.Name, service.ID)
+	err = s.client.SetArgs(ctx, key, data, redis.SetArgs{TTL: serviceTTL, Get: true}).Err()
+	if err == redis.Nil {
+		return false, nil
+	}
+	return err == nil, err
 }
 
-func (s *RedisStore) UpdateTTL(ctx context.Context, serviceName string) error {
-	if serviceName == "" {
+func (s *RedisStore) UpdateTTL(ctx context.Context, serviceName, instanceID string) error {
+	if serviceName == "" || instanceID == "" {
 		return ErrInvalidService
 	}
 
-	exists, err := s.client.Exists(ctx, serviceName).Result()
+	key := instanceKey(serviceName, instanceID)
+	exists, err := s.client.Exists(ctx, key).Result()
 	if err != nil {
 		return err
 	}
@@ -73,11 +81,12 @@ func (s *RedisStore) UpdateTTL(ctx context.Context, serviceName string) error {
 		return ErrServiceNotFound
 	}
 
-	return s.client.Expire(ctx, serviceName, serviceTTL).Err()
+	return s.client.Expire(ctx, key, serviceTTL).Err()
 }
 
+// GetServices returns every live instance of every registered service.
 func (s *RedisStore) GetServices(ctx context.Context) ([]*models.Service, error) {
-	keys, err := s.client.Keys(ctx, "*").Result()
+	keys, err := s.client.Keys(ctx, keyPrefix+"*").Result()
 	if err != nil {
 		return nil, err
 	}
@@ -111,4 +120,9 @@ func (s *RedisStore) Ping() error {
 
 func (s *RedisStore) Close() error {
 	return s.client.Close()
-} 
\ No newline at end of file
+}
+
+// PoolStats returns the connection pool statistics of the Redis client.
+func (s *RedisStore) PoolStats() *redis.PoolStats {
+	return s.client.PoolStats()
+}
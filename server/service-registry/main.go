@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/blogging-platform/service-registry/internal/handlers"
+	"github.com/omsurase/blogger_microservices/server/pkg/metrics"
 	"github.com/blogging-platform/service-registry/internal/store"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -13,6 +19,8 @@ func main() {
 	logger := logrus.New()
 	logger.SetFormatter(&logrus.JSONFormatter{})
 
+	metrics.Init("service-registry")
+
 	redisAddr := os.Getenv("REDIS_ADDR")
 	if redisAddr == "" {
 		redisAddr = "localhost:6379"
@@ -23,10 +31,13 @@ func main() {
 		logger.WithError(err).Fatal("Failed to connect to Redis")
 	}
 	defer redisStore.Close()
+	metrics.RegisterRedis(func() metrics.RedisPoolStats { return metrics.RedisPoolStats(*redisStore.PoolStats()) }, "redis")
 
 	handler := handlers.NewHandler(redisStore, logger)
 
 	router := gin.Default()
+	router.Use(metrics.Middleware())
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
 
 	router.POST("/register", handler.RegisterService)
 	router.POST("/heartbeat", handler.Heartbeat)
@@ -38,8 +49,36 @@ func main() {
 		port = "8080"
 	}
 
-	logger.WithField("port", port).Info("Starting service registry")
-	if err := router.Run(":" + port); err != nil {
-		logger.WithError(err).Fatal("Failed to start server")
+	shutdownTimeout := 10 * time.Second
+	if v := os.Getenv("SHUTDOWN_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			logger.WithError(err).Fatal("Invalid SHUTDOWN_TIMEOUT")
+		}
+		shutdownTimeout = d
+	}
+
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: router,
+	}
+
+	go func() {
+		logger.WithField("port", port).Info("Starting service registry")
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.WithError(err).Fatal("Failed to start server")
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	// Finish in-flight registrations and heartbeats before Redis is closed
+	logger.Info("Shutting down service registry")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.WithError(err).Error("Service registry forced to shut down")
 	}
 } 
\ No newline at end of file
@@ -109,16 +109,16 @@ func (h *ProfileHandler) UpdateProfile(c *gin.Context) {
 	sendSuccess(c, http.StatusOK, updatedProfile)
 }
 
+// AuthMiddleware requires the caller identity established by the identity
+// middleware from the gateway's signed assertion.
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userID := c.GetHeader("X-User-ID")
-		if userID == "" {
-			sendError(c, http.StatusUnauthorized, "X-User-ID header missing", nil)
+		if c.GetString("user_id") == "" {
+			sendError(c, http.StatusUnauthorized, "Authentication required", nil)
 			c.Abort()
 			return
 		}
 
-		c.Set("user_id", userID)
 		c.Next()
 	}
 } 
\ No newline at end of file
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/omsurase/blogger_microservices/server/pkg/openapi"
+	"github.com/omsurase/blogger_microservices/server/user-profile/internal/models"
+)
+
+// APISpec documents the routes main registers; main checks that the two
+// agree.
+func APISpec(version string) *openapi.Spec {
+	spec := openapi.New("User Profile Service", version, models.SuccessResponse{}, models.ErrorResponse{})
+	spec.Add(
+		openapi.Operation{
+			Method: http.MethodGet, Path: "/profile/:id", Summary: "Get the profile of a user, creating an empty one if there is none",
+			Response: models.Profile{},
+			Errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusInternalServerError},
+		},
+		openapi.Operation{
+			Method: http.MethodPut, Path: "/profile/update", Summary: "Update the caller's profile", Auth: true,
+			Request: models.UpdateProfileRequest{}, Response: models.Profile{},
+			Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusUnsupportedMediaType, http.StatusInternalServerError},
+		},
+	)
+	return spec
+}
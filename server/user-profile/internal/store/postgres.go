@@ -134,4 +134,9 @@ func (s *PostgresStore) UpdateProfile(userID uuid.UUID, req *models.UpdateProfil
 		return nil, fmt.Errorf("error updating profile: %v", err)
 	}
 	return profile, nil
-} 
\ No newline at end of file
+}
+
+// DB returns the underlying connection pool, for metrics.
+func (s *PostgresStore) DB() *sql.DB {
+	return s.db
+}
@@ -13,8 +13,12 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"github.com/omsurase/blogger_microservices/server/user-profile/internal/handlers"
+	"github.com/omsurase/blogger_microservices/server/pkg/metrics"
+	"github.com/omsurase/blogger_microservices/server/pkg/identity"
 	"github.com/omsurase/blogger_microservices/server/user-profile/internal/store"
+	"github.com/omsurase/blogger_microservices/server/pkg/tracing"
 )
 
 const (
@@ -24,12 +28,30 @@ const (
 	heartbeatDelay = 30 * time.Second
 )
 
+// API key scopes that let callers read and change profiles.
+const (
+	scopeProfileRead  = "profile:read"
+	scopeProfileWrite = "profile:write"
+)
+
 func main() {
+	metrics.Init(serviceName)
+
+	shutdownTracing, err := tracing.Init(context.Background(), serviceName)
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+
 	dbURL := os.Getenv("DATABASE_URL")
 	if dbURL == "" {
 		log.Fatal("DATABASE_URL environment variable is required")
 	}
 
+	identitySecret := os.Getenv("INTERNAL_IDENTITY_SECRET")
+	if identitySecret == "" {
+		log.Fatal("INTERNAL_IDENTITY_SECRET environment variable is required")
+	}
+
 	store, err := store.NewPostgresStore(dbURL)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
@@ -39,9 +61,14 @@ func main() {
 	if err := store.InitDB(); err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
+	metrics.RegisterDB(store.DB(), "postgres")
 
 	profileHandler := handlers.NewProfileHandler(store)
 	router := gin.Default()
+	router.Use(otelgin.Middleware(serviceName))
+	router.Use(metrics.Middleware())
+	router.Use(identity.NewVerifier([]byte(identitySecret), serviceName).Middleware())
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
 
 	// Health endpoint
 	router.GET("/profile/health", func(c *gin.Context) {
@@ -51,8 +78,15 @@ func main() {
 		})
 	})
 
-	router.GET("/profile/:id", profileHandler.GetProfile)
-	router.PUT("/profile/update", handlers.AuthMiddleware(), profileHandler.UpdateProfile)
+	router.GET("/profile/:id", identity.RequireScope(scopeProfileRead), profileHandler.GetProfile)
+	router.PUT("/profile/update", handlers.AuthMiddleware(), identity.RequireScope(scopeProfileWrite), profileHandler.UpdateProfile)
+
+	// OpenAPI document of the routes above, merged into the gateway's docs
+	apiSpec := handlers.APISpec(os.Getenv("SERVICE_VERSION"))
+	router.GET("/profile/openapi.json", apiSpec.Handler())
+	if err := apiSpec.Check(router.Routes(), "/metrics", "/profile/health", "/profile/openapi.json"); err != nil {
+		log.Fatalf("API documentation is out of date: %v", err)
+	}
 
 	srv := &http.Server{
 		Addr:    ":8080",
@@ -82,6 +116,10 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
+
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("Failed to flush traces: %v", err)
+	}
 }
 
 func registerService() error {
@@ -100,8 +138,10 @@ func registerService() error {
 
 	for i := 0; i < retryAttempts; i++ {
 		payload := map[string]string{
+			"id":      hostname,
 			"name":    serviceName,
 			"address": serviceAddress,
+			"version": os.Getenv("SERVICE_VERSION"),
 		}
 
 		jsonPayload, err := json.Marshal(payload)
@@ -129,10 +169,18 @@ func startHeartbeat() {
 	registryURL := os.Getenv("REGISTRY_URL")
 	heartbeatURL := fmt.Sprintf("%s/heartbeat", registryURL)
 
+	// The hostname doubles as the instance ID used at registration time.
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Printf("Failed to get hostname for heartbeat: %v", err)
+		return
+	}
+
 	ticker := time.NewTicker(heartbeatDelay)
 	go func() {
 		for range ticker.C {
 			payload := map[string]string{
+				"id":   hostname,
 				"name": serviceName,
 			}
 